@@ -0,0 +1,211 @@
+package steamscreenshots
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Albums are user defined collections of screenshots from any game.  They
+// only reference images by appid and filename; images that have since been
+// removed are skipped when an album is displayed.
+
+type AlbumImage struct {
+	AppId    string
+	Filename string
+}
+
+type Album struct {
+	Id          string
+	Name        string
+	Description string
+	Cover       *AlbumImage  // The first image is used if this is nil
+	Images      []AlbumImage // In display order
+
+	Created time.Time
+	Updated time.Time
+}
+
+// CoverImage returns the image to show for the album, if there is one.
+func (a Album) CoverImage() (AlbumImage, bool) {
+	if a.Cover != nil {
+		return *a.Cover, true
+	}
+	if len(a.Images) > 0 {
+		return a.Images[0], true
+	}
+	return AlbumImage{}, false
+}
+
+func (a Album) clone() Album {
+	if a.Cover != nil {
+		cover := *a.Cover
+		a.Cover = &cover
+	}
+	a.Images = slices.Clone(a.Images)
+	return a
+}
+
+type AlbumList struct {
+	albums   map[string]*Album
+	m        sync.Mutex
+	filename string
+}
+
+func LoadAlbums(filename string) (*AlbumList, error) {
+	al := &AlbumList{
+		albums:   make(map[string]*Album),
+		filename: filename,
+	}
+
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return al, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, &al.albums)
+	if err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// save writes the albums to disk.  It expects the lock to be held.  Errors
+// are only logged; changes are kept in memory and saved with the next one.
+func (al *AlbumList) save() {
+	if err := al.write(); err != nil {
+		fmt.Println("unable to save albums:", err)
+	}
+}
+
+// write is save for callers that need to know whether it worked.  It expects
+// the lock to be held.
+func (al *AlbumList) write() error {
+	if al.filename == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(al.albums, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(al.filename, raw, 0644)
+}
+
+func (al *AlbumList) Get(id string) (Album, bool) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	album, ok := al.albums[id]
+	if !ok {
+		return Album{}, false
+	}
+	return album.clone(), true
+}
+
+// List returns every album sorted by name.
+func (al *AlbumList) List() []Album {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	albums := []Album{}
+	for _, album := range al.albums {
+		albums = append(albums, album.clone())
+	}
+
+	slices.SortFunc(albums, func(a, b Album) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return albums
+}
+
+// Create adds a new album with a generated Id.
+func (al *AlbumList) Create(album Album) (Album, error) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	id, err := newAlbumId()
+	for err == nil && al.albums[id] != nil {
+		id, err = newAlbumId()
+	}
+	if err != nil {
+		return Album{}, err
+	}
+
+	album.Id = id
+	album.Created = time.Now()
+	album.Updated = album.Created
+	al.albums[id] = &album
+	al.save()
+
+	return album.clone(), nil
+}
+
+// Update calls edit with a copy of the album and saves it if edit doesn't
+// return an error.  The album is unchanged if edit fails.
+func (al *AlbumList) Update(id string, edit func(album *Album) error) (Album, bool, error) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	current, ok := al.albums[id]
+	if !ok {
+		return Album{}, false, nil
+	}
+
+	album := current.clone()
+	if err := edit(&album); err != nil {
+		return Album{}, true, err
+	}
+
+	album.Id = id
+	album.Updated = time.Now()
+	al.albums[id] = &album
+	al.save()
+
+	return album.clone(), true, nil
+}
+
+func (al *AlbumList) Delete(id string) bool {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	if _, ok := al.albums[id]; !ok {
+		return false
+	}
+
+	delete(al.albums, id)
+	al.save()
+	return true
+}
+
+func newAlbumId() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// albumMetadata returns the metadata for an album's images, in album order.
+// Images that no longer exist are skipped.
+func (s *Server) albumMetadata(album Album) []Metadata {
+	images := []Metadata{}
+	for _, ref := range album.Images {
+		md, ok := s.ImageCache.GetImage(ref.AppId, ref.Filename)
+		if !ok {
+			continue
+		}
+		md.Game = s.Games.Get(ref.AppId)
+		images = append(images, md)
+	}
+	return images
+}
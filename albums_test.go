@@ -0,0 +1,138 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAlbumList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), AlbumsFile)
+	al, err := LoadAlbums(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	album, err := al.Create(Album{Name: "Best", Images: []AlbumImage{{"220", "a.jpg"}, {"70", "b.jpg"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed edit leaves the album as it was.
+	_, found, err := al.Update(album.Id, func(album *Album) error {
+		album.Name = "Changed"
+		return fmt.Errorf("nope")
+	})
+	if !found || err == nil {
+		t.Fatalf("Update returned %v, %v", found, err)
+	}
+
+	_, found, err = al.Update(album.Id, func(album *Album) error {
+		album.Cover = &album.Images[1]
+		return nil
+	})
+	if !found || err != nil {
+		t.Fatalf("Update returned %v, %v", found, err)
+	}
+	if _, found, _ = al.Update("missing", func(*Album) error { return nil }); found {
+		t.Error("updated a missing album")
+	}
+
+	reloaded, err := LoadAlbums(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, ok := reloaded.Get(album.Id)
+	if !ok || saved.Name != "Best" || len(saved.Images) != 2 {
+		t.Fatalf("unexpected album after reloading: %+v", saved)
+	}
+	if cover, _ := saved.CoverImage(); cover != (AlbumImage{"70", "b.jpg"}) {
+		t.Errorf("unexpected cover: %v", cover)
+	}
+
+	if !reloaded.Delete(album.Id) || reloaded.Delete(album.Id) {
+		t.Error("Delete didn't remove the album exactly once")
+	}
+	if reloaded, _ = LoadAlbums(filename); len(reloaded.List()) != 0 {
+		t.Errorf("deleted album was saved: %v", reloaded.List())
+	}
+}
+
+func TestAlbumApi(t *testing.T) {
+	s := newTestServer(t, newFakeSteam(t), newTestGameList(t), Settings{
+		ApiKey:        "key",
+		ApiWhitelist:  []string{"192.0.2.1"},
+		NameResolvers: []string{ResolverCache},
+	})
+	s.Albums = &AlbumList{albums: make(map[string]*Album)}
+	s.ImageCache = NewGameImages()
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{"a.jpg": {}, "b.jpg": {}}
+	s.ImageCache.Games["70"] = map[string]*ImageMeta{"c.jpg": {}}
+
+	send := func(handler http.HandlerFunc, method, id, body string) (*httptest.ResponseRecorder, ApiAlbum) {
+		req := httptest.NewRequest(method, "/api/v1/albums/"+id, strings.NewReader(body))
+		req.SetPathValue("id", id)
+		req.Header.Set("api-key", "key")
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		album := ApiAlbum{}
+		if w.Code < 300 && w.Body.Len() > 0 {
+			if err := json.Unmarshal(w.Body.Bytes(), &album); err != nil {
+				t.Fatalf("%s: %s", err, w.Body)
+			}
+		}
+		return w, album
+	}
+
+	// Images have to exist, and so does the cover.
+	for _, body := range []string{
+		`{"name": "Best", "images": [{"appid": "220", "filename": "missing.jpg"}]}`,
+		`{"name": "Best", "images": [{"appid": "220", "filename": "a.jpg"}], "cover": {"appid": "70", "filename": "c.jpg"}}`,
+		`{"images": []}`,
+	} {
+		if w, _ := send(s.handler_api_v1_create_album, "POST", "", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	w, album := send(s.handler_api_v1_create_album, "POST", "", `{"name": " Best ", "images": [
+		{"appid": "220", "filename": "b.jpg"},
+		{"appid": "70", "filename": "c.jpg"}
+	], "cover": {"appid": "70", "filename": "c.jpg"}}`)
+	if w.Code != http.StatusCreated || album.Name != "Best" || album.Count != 2 || album.Urls.Thumbnail != "/thumb/70/c.jpg" {
+		t.Fatalf("unexpected album: %d %+v", w.Code, album)
+	}
+
+	// Images already in the album stay where they are.
+	w, album = send(s.handler_api_v1_add_album_images, "POST", album.Id, `{"images": [
+		{"appid": "220", "filename": "a.jpg"},
+		{"appid": "220", "filename": "b.jpg"}
+	]}`)
+	if w.Code != 200 || len(album.Images) != 3 || album.Images[0].Filename != "b.jpg" || album.Images[2].Filename != "a.jpg" {
+		t.Fatalf("unexpected album after adding images: %d %+v", w.Code, album)
+	}
+
+	// Replacing the images drops a cover that was removed.
+	w, album = send(s.handler_api_v1_edit_album, "PATCH", album.Id, `{"images": [{"appid": "220", "filename": "a.jpg"}]}`)
+	if w.Code != 200 || album.Cover != nil || album.Urls.Thumbnail != "/thumb/220/a.jpg" {
+		t.Errorf("unexpected album after replacing images: %d %+v", w.Code, album)
+	}
+
+	// Images deleted from the library are skipped.
+	delete(s.ImageCache.Games["220"], "a.jpg")
+	if w, album = send(s.handler_api_v1_album, "GET", album.Id, ""); w.Code != 200 || album.Count != 0 || len(album.Images) != 0 {
+		t.Errorf("unexpected album after removing its image: %d %+v", w.Code, album)
+	}
+
+	if w, _ = send(s.handler_api_v1_delete_album, "DELETE", album.Id, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: expected 204, got %d", w.Code)
+	}
+	if w, _ = send(s.handler_api_v1_album, "GET", album.Id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted album: expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,297 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Aliases merge the screenshots of several appids, like a game's demo or
+// beta, into one primary game.  Aliases get no tile of their own on the main
+// list and their pages redirect to the primary's, which shows everything.
+// The files stay where they are unless they're moved with MoveImages.
+
+// AliasesFile holds the aliases.
+const AliasesFile = "aliases.json"
+
+type GameAlias struct {
+	Appid   string `json:"id"`
+	Primary string `json:"primary"`
+}
+
+type AliasList struct {
+	aliases  map[string]string // alias to primary
+	m        sync.Mutex
+	filename string
+}
+
+func LoadAliases(filename string) (*AliasList, error) {
+	al := &AliasList{
+		aliases:  make(map[string]string),
+		filename: filename,
+	}
+
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return al, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	aliases := []GameAlias{}
+	if err = json.Unmarshal(raw, &aliases); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", filename, err)
+	}
+
+	for _, alias := range aliases {
+		al.aliases[alias.Appid] = alias.Primary
+	}
+	return al, nil
+}
+
+// save writes the aliases to disk.  It expects the lock to be held.
+func (al *AliasList) save() error {
+	if al.filename == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(al.list(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(al.filename, raw, 0644)
+}
+
+// Primary returns the game appid is an alias of, or appid itself.
+func (al *AliasList) Primary(appid string) string {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	if primary, ok := al.aliases[appid]; ok {
+		return primary
+	}
+	return appid
+}
+
+// Members returns appid followed by its aliases.
+func (al *AliasList) Members(appid string) []string {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	members := []string{}
+	for alias, primary := range al.aliases {
+		if primary == appid {
+			members = append(members, alias)
+		}
+	}
+	slices.Sort(members)
+	return append([]string{appid}, members...)
+}
+
+// List returns every alias sorted by primary.
+func (al *AliasList) List() []GameAlias {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	return al.list()
+}
+
+func (al *AliasList) list() []GameAlias {
+	aliases := []GameAlias{}
+	for appid, primary := range al.aliases {
+		aliases = append(aliases, GameAlias{Appid: appid, Primary: primary})
+	}
+
+	slices.SortFunc(aliases, func(a, b GameAlias) int {
+		if c := strings.Compare(a.Primary, b.Primary); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Appid, b.Appid)
+	})
+	return aliases
+}
+
+// Set makes appid an alias of primary.  If primary is an alias itself, its
+// primary is used instead, and appid's own aliases move along with it so
+// there's only ever one level.  It returns the primary that was used, which
+// is appid if that would make a loop.  Nothing is changed in that case, or if
+// the aliases can't be saved.
+func (al *AliasList) Set(appid, primary string) (used string, created bool, err error) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	if p, ok := al.aliases[primary]; ok {
+		primary = p
+	}
+	if primary == appid {
+		return appid, false, nil
+	}
+
+	old := maps.Clone(al.aliases)
+	_, exists := al.aliases[appid]
+	al.aliases[appid] = primary
+	for alias, p := range al.aliases {
+		if p == appid {
+			al.aliases[alias] = primary
+		}
+	}
+
+	if err = al.save(); err != nil {
+		al.aliases = old
+		return primary, false, err
+	}
+	return primary, !exists, nil
+}
+
+// Delete removes an alias.  ok is false if appid wasn't one.  The alias is
+// kept if the change can't be saved.
+func (al *AliasList) Delete(appid string) (ok bool, err error) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	primary, ok := al.aliases[appid]
+	if !ok {
+		return false, nil
+	}
+
+	delete(al.aliases, appid)
+	if err = al.save(); err != nil {
+		al.aliases[appid] = primary
+	}
+	return true, err
+}
+
+// hasGame reports whether appid or any of its aliases have images.
+func (s *Server) hasGame(appid string) bool {
+	s.ImageCache.lock.RLock()
+	defer s.ImageCache.lock.RUnlock()
+
+	for _, member := range s.Aliases.Members(appid) {
+		if _, ok := s.ImageCache.Games[member]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// gameMetadata returns the images of appid and its aliases, oldest first.
+func (s *Server) gameMetadata(appid string) []Metadata {
+	images := []Metadata{}
+	for _, member := range s.Aliases.Members(appid) {
+		if s.ImageCache.Count(member) > 0 {
+			images = append(images, s.ImageCache.GetMetadata(member)...)
+		}
+	}
+
+	slices.SortFunc(images, compareMetadata)
+	return images
+}
+
+// gameFavorites returns the starred images of appid and its aliases, newest
+// first.
+func (s *Server) gameFavorites(appid string) []Metadata {
+	images := []Metadata{}
+	for _, member := range s.Aliases.Members(appid) {
+		images = append(images, s.ImageCache.Favorites(member)...)
+	}
+
+	slices.SortFunc(images, newestFirst)
+	return images
+}
+
+// MoveImages moves the images of from into to's directory, along with their
+// thumbnails and previews.  Images with a filename that's already used in to
+// are left where they are and returned in skipped.  from's directory is
+// removed if it ends up empty.
+func (gi *GameImages) MoveImages(from, to string) (moved, skipped []string, err error) {
+	gi.lock.Lock()
+	defer gi.lock.Unlock()
+
+	for _, sub := range []string{"thumbnails", "previews"} {
+		if err := os.MkdirAll(filepath.Join(gi.Root, to, sub), 0755); err != nil {
+			return nil, nil, err
+		}
+	}
+	if gi.Games[to] == nil {
+		gi.Games[to] = make(map[string]*ImageMeta)
+	}
+
+	filenames := []string{}
+	for filename := range gi.Games[from] {
+		filenames = append(filenames, filename)
+	}
+	slices.Sort(filenames)
+
+	for _, filename := range filenames {
+		if _, taken := gi.Games[to][filename]; taken || exists(filepath.Join(gi.Root, to, filename)) {
+			skipped = append(skipped, filename)
+			continue
+		}
+
+		err = os.Rename(filepath.Join(gi.Root, from, filename), filepath.Join(gi.Root, to, filename))
+		if err != nil {
+			break
+		}
+
+		// Missing ones are made again on the next scan.
+		for _, sub := range []string{"thumbnails", "previews"} {
+			os.Rename(filepath.Join(gi.Root, from, sub, filename), filepath.Join(gi.Root, to, sub, filename))
+		}
+
+		gi.Games[to][filename] = gi.Games[from][filename]
+		delete(gi.Games[from], filename)
+		moved = append(moved, filename)
+	}
+
+	if len(gi.Games[from]) == 0 {
+		delete(gi.Games, from)
+
+		// Only empty directories are removed.
+		os.Remove(filepath.Join(gi.Root, from, "thumbnails"))
+		os.Remove(filepath.Join(gi.Root, from, "previews"))
+		os.Remove(filepath.Join(gi.Root, from))
+	}
+
+	gi.version++
+	if gi.filename == "" {
+		return moved, skipped, err
+	}
+	if saveErr := gi.save(); err == nil {
+		err = saveErr
+	}
+	return moved, skipped, err
+}
+
+// MoveImages points references to the given images of from at to instead.
+func (al *AlbumList) MoveImages(from, to string, filenames []string) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	move := func(img *AlbumImage) bool {
+		if img.AppId == from && slices.Contains(filenames, img.Filename) {
+			img.AppId = to
+			return true
+		}
+		return false
+	}
+
+	changed := false
+	for _, album := range al.albums {
+		for i := range album.Images {
+			changed = move(&album.Images[i]) || changed
+		}
+		if album.Cover != nil {
+			changed = move(album.Cover) || changed
+		}
+	}
+
+	if changed {
+		al.save()
+	}
+}
@@ -0,0 +1,189 @@
+package steamscreenshots
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAliasList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), AliasesFile)
+	al, err := LoadAliases(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Aliases of aliases use the first game as their primary.
+	al.Set("102", "100")
+	if primary, created, _ := al.Set("101", "102"); primary != "100" || !created {
+		t.Errorf("Set returned %q, %v", primary, created)
+	}
+	if primary, _, _ := al.Set("100", "101"); primary != "100" {
+		t.Errorf("made a loop: %q", primary)
+	}
+
+	// Moving a primary takes its aliases along.
+	al.Set("100", "200")
+
+	reloaded, err := LoadAliases(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members := reloaded.Members("200"); !slices.Equal(members, []string{"200", "100", "101", "102"}) {
+		t.Errorf("unexpected members after reloading: %v", members)
+	}
+
+	if ok, _ := reloaded.Delete("101"); !ok || reloaded.Primary("101") != "101" {
+		t.Error("alias wasn't deleted")
+	}
+}
+
+func TestMoveImages(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"100/a.jpg", "100/thumbnails/a.jpg", "100/b.jpg", "200/b.jpg"} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755)
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gi := NewGameImages()
+	gi.Root = root
+	gi.Games["100"] = map[string]*ImageMeta{"a.jpg": {Caption: "moved"}, "b.jpg": {}}
+	gi.Games["200"] = map[string]*ImageMeta{"b.jpg": {}}
+
+	moved, skipped, err := gi.MoveImages("100", "200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(moved, []string{"a.jpg"}) || !slices.Equal(skipped, []string{"b.jpg"}) {
+		t.Errorf("moved %v, skipped %v", moved, skipped)
+	}
+
+	if !exists(filepath.Join(root, "200/a.jpg")) || !exists(filepath.Join(root, "200/thumbnails/a.jpg")) {
+		t.Error("file or thumbnail wasn't moved")
+	}
+	if gi.Games["200"]["a.jpg"].Caption != "moved" {
+		t.Error("metadata wasn't moved")
+	}
+	if !exists(filepath.Join(root, "100/b.jpg")) || gi.Games["100"]["b.jpg"] == nil {
+		t.Error("skipped file went missing")
+	}
+}
+
+func TestAliasPages(t *testing.T) {
+	if err := init_templates(); err != nil {
+		t.Fatal(err)
+	}
+
+	games := newTestGameList(t)
+	s := newTestServer(t, newFakeSteam(t), games, Settings{NameResolvers: []string{ResolverCache}})
+	s.ImageCache = NewGameImages()
+
+	now := time.Now()
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{"a.jpg": {CapturedAt: now}}
+	s.ImageCache.Games["221"] = map[string]*ImageMeta{"b.jpg": {CapturedAt: now.Add(-time.Hour)}}
+	games.Set("220", "Kerbal Space Program")
+	games.Set("221", "Kerbal Space Program Demo")
+	s.Aliases.Set("221", "220")
+
+	w := httptest.NewRecorder()
+	s.handler_main(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); strings.Contains(body, `href="/game/221/"`) || !strings.Contains(body, "(2)") {
+		t.Error("alias wasn't merged into its primary's tile")
+	}
+
+	req := httptest.NewRequest("GET", "/game/221/", nil)
+	req.SetPathValue("appid", "221")
+	w = httptest.NewRecorder()
+	s.handler_game(w, req)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/game/220/" {
+		t.Errorf("alias page wasn't redirected: %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	if images := s.gameMetadata("220"); len(images) != 2 || images[0].AppId != "221" {
+		t.Errorf("unexpected merged gallery: %+v", images)
+	}
+}
+
+func TestAliasDownload(t *testing.T) {
+	dir := t.TempDir()
+	games := newTestGameList(t)
+	s := newTestServer(t, newFakeSteam(t), games, Settings{ImageDirectory: dir, NameResolvers: []string{ResolverCache}})
+	s.ImageCache = NewGameImages()
+
+	for _, appid := range []string{"220", "221"} {
+		os.MkdirAll(filepath.Join(dir, appid), 0755)
+		os.WriteFile(filepath.Join(dir, appid, "a.jpg"), []byte(appid), 0644)
+		s.ImageCache.Games[appid] = map[string]*ImageMeta{"a.jpg": {}}
+	}
+	s.Aliases.Set("221", "220")
+
+	req := httptest.NewRequest("GET", "/game/220/download.zip", nil)
+	req.SetPathValue("appid", "220")
+	w := httptest.NewRecorder()
+	s.handler_download_game(w, req)
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"221/a.jpg", "a.jpg"}) {
+		t.Errorf("unexpected zip entries: %v", names)
+	}
+
+	// The API merges aliases too.
+	req = httptest.NewRequest("GET", "/api/v1/games/221/images", nil)
+	req.SetPathValue("appid", "221")
+	w = httptest.NewRecorder()
+	s.handler_api_v1_game_images(w, req)
+	if strings.Count(w.Body.String(), `"filename"`) != 2 {
+		t.Errorf("API didn't merge aliases: %s", w.Body)
+	}
+}
+
+func TestAliasSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, newFakeSteam(t), newTestGameList(t), Settings{
+		ImageDirectory: dir,
+		ApiKey:         "key",
+		ApiWhitelist:   []string{"192.0.2.1"},
+		NameResolvers:  []string{ResolverCache},
+	})
+	s.ImageCache = NewGameImages()
+	s.ImageCache.Root = dir
+	s.Aliases.filename = filepath.Join(t.TempDir(), "missing", AliasesFile)
+
+	os.MkdirAll(filepath.Join(dir, "221"), 0755)
+	os.WriteFile(filepath.Join(dir, "221", "a.jpg"), []byte("a"), 0644)
+	s.ImageCache.Games["221"] = map[string]*ImageMeta{"a.jpg": {}}
+
+	req := httptest.NewRequest("PUT", "/api/v1/aliases/221", strings.NewReader(`{"primary": "220", "move": true}`))
+	req.SetPathValue("appid", "221")
+	req.Header.Set("api-key", "key")
+	w := httptest.NewRecorder()
+	s.handler_api_v1_set_alias(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+
+	if primary := s.Aliases.Primary("221"); primary != "221" {
+		t.Errorf("unsaved alias was kept: %q", primary)
+	}
+	if !exists(filepath.Join(dir, "221", "a.jpg")) || s.ImageCache.Count("221") != 1 {
+		t.Error("images were moved")
+	}
+}
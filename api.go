@@ -1,14 +1,22 @@
 package steamscreenshots
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 func (s *Server) handler_api_cache(w http.ResponseWriter, r *http.Request) {
@@ -17,7 +25,9 @@ func (s *Server) handler_api_cache(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Println("serving image.cache")
 
+	s.ImageCache.lock.RLock()
 	raw, err := json.Marshal(s.ImageCache.Games)
+	s.ImageCache.lock.RUnlock()
 	if err != nil {
 		fmt.Println(err)
 
@@ -48,6 +58,9 @@ func (s *Server) handler_api_upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Video clips can take longer to upload than the server's read timeout.
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+
 	fullname := filepath.Join(s.settings.ImageDirectory, appid, filename)
 	err := os.MkdirAll(filepath.Dir(fullname), 0755)
 	if err != nil {
@@ -59,7 +72,20 @@ func (s *Server) handler_api_upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	output, err := os.Create(fullname)
+	info, err := parseUploadHeaders(r.Header)
+	if err != nil {
+		fmt.Println(err)
+		sendApiError(w, ApiError{
+			Code: http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Write to a temporary file first so a failed or corrupt upload never
+	// replaces anything.  The leading dot and lack of an extension keeps it
+	// out of directory scans.
+	output, err := os.CreateTemp(filepath.Dir(fullname), ".upload-*")
 	if err != nil {
 		fmt.Println(err)
 		sendApiError(w, ApiError{
@@ -68,25 +94,249 @@ func (s *Server) handler_api_upload(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	defer output.Close()
+	tmpname := output.Name()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(output, hash), r.Body)
+	output.Close()
+	if err == nil {
+		err = info.verify(size, hash.Sum(nil))
+	}
+
+	if err == nil && !info.ModTime.IsZero() {
+		err = os.Chtimes(tmpname, info.ModTime, info.ModTime)
+	}
+
+	// CreateTemp makes the file 0600; screenshots have always been readable
+	// by everyone.
+	if err == nil {
+		err = os.Chmod(tmpname, 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmpname, fullname)
+	}
 
-	_, err = io.Copy(output, r.Body)
 	if err != nil {
 		fmt.Println(err)
 		sendApiError(w, ApiError{
 			Code: http.StatusBadRequest,
-			Message: fmt.Sprintf("unable to read image: %s", err.Error()),
+			Message: fmt.Sprintf("unable to save image: %s", err.Error()),
 		})
-		output.Close()
-		err = os.Remove(fullname)
+		err = os.Remove(tmpname)
 		if err != nil {
-			fmt.Println("unable to remove incomplete file %s: %s", fullname, err.Error())
+			fmt.Printf("unable to remove incomplete file %s: %s\n", tmpname, err.Error())
 		}
 		return
 	}
 
 	fmt.Printf("[%s] %s uploaded\n", appid, filename)
-	s.newImages <- NewImage{AppId: appid, Filename: filename}
+	s.newImages <- NewImage{AppId: appid, Filename: filename, Steam: info.Steam}
+}
+
+// ImagePage is one page of a paginated image list.
+type ImagePage struct {
+	Items []Metadata `json:"items"`
+	Next  string     `json:"next,omitempty"` // cursor for the next page
+}
+
+// handler_api_timeline returns screenshots from every game, newest first.
+func (s *Server) handler_api_timeline(w http.ResponseWriter, r *http.Request) {
+	page, err := s.timelinePage(r.URL.Query().Get("cursor"), pageLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	sendJson(w, page)
+}
+
+// handler_api_game_images returns one page of a game's gallery, oldest first.
+func (s *Server) handler_api_game_images(w http.ResponseWriter, r *http.Request) {
+	appid := s.Aliases.Primary(r.PathValue("appid"))
+	if !s.hasGame(appid) {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "appid not found",
+		})
+		return
+	}
+
+	images := s.gameMetadata(appid)
+	items, next, err := paginate(images, compareMetadata, r.URL.Query().Get("cursor"), pageLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	sendJson(w, &ImagePage{Items: items, Next: next})
+}
+
+func (s *Server) timelinePage(cursor string, limit int) (*ImagePage, error) {
+	images := s.ImageCache.AllMetadata()
+	slices.Reverse(images)
+
+	items, next, err := paginate(images, newestFirst, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fillGameNames(items)
+	return &ImagePage{Items: items, Next: next}, nil
+}
+
+// favoritesPage returns starred images from every game, newest first.
+func (s *Server) favoritesPage(cursor string, limit int) (*ImagePage, error) {
+	items, next, err := paginate(s.ImageCache.Favorites(""), newestFirst, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fillGameNames(items)
+	return &ImagePage{Items: items, Next: next}, nil
+}
+
+// fillGameNames sets Game on images from a mix of games.
+func (s *Server) fillGameNames(items []Metadata) {
+	names := map[string]string{}
+	for i := range items {
+		name, ok := names[items[i].AppId]
+		if !ok {
+			name, _ = s.getGameName(items[i].AppId)
+			names[items[i].AppId] = name
+		}
+		items[i].Game = name
+	}
+}
+
+// handler_api_favorites returns starred screenshots from every game.
+func (s *Server) handler_api_favorites(w http.ResponseWriter, r *http.Request) {
+	page, err := s.favoritesPage(r.URL.Query().Get("cursor"), pageLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	sendJson(w, page)
+}
+
+// Headers sent by the uploader describing the original file.  All of them are
+// optional.
+const (
+	HeaderFileMtime  = "X-File-Mtime"  // RFC 3339
+	HeaderFileSize   = "X-File-Size"   // bytes
+	HeaderFileSha256 = "X-File-Sha256" // hex encoded
+
+	// Details from Steam's screenshots.vdf.  Text is percent encoded since
+	// captions can be anything.
+	HeaderSteamCaption         = "X-Steam-Caption"
+	HeaderSteamLocation        = "X-Steam-Location"
+	HeaderSteamCreated         = "X-Steam-Created" // RFC 3339
+	HeaderSteamPublishedFileId = "X-Steam-Published-File-Id"
+)
+
+type uploadInfo struct {
+	ModTime time.Time
+	Size    int64 // -1 if not given
+	Sha256  []byte
+	Steam   *SteamScreenshot
+}
+
+func parseUploadHeaders(header http.Header) (uploadInfo, error) {
+	info := uploadInfo{Size: -1}
+	var err error
+
+	if val := header.Get(HeaderFileMtime); val != "" {
+		info.ModTime, err = time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return info, fmt.Errorf("invalid %s header: %w", HeaderFileMtime, err)
+		}
+	}
+
+	if val := header.Get(HeaderFileSize); val != "" {
+		info.Size, err = strconv.ParseInt(val, 10, 64)
+		if err != nil || info.Size < 0 {
+			return info, fmt.Errorf("invalid %s header: %q", HeaderFileSize, val)
+		}
+	}
+
+	if val := header.Get(HeaderFileSha256); val != "" {
+		info.Sha256, err = hex.DecodeString(val)
+		if err != nil || len(info.Sha256) != sha256.Size {
+			return info, fmt.Errorf("invalid %s header: %q", HeaderFileSha256, val)
+		}
+	}
+
+	info.Steam, err = parseSteamHeaders(header)
+	return info, err
+}
+
+// parseSteamHeaders returns nil if none of the Steam headers are given.
+func parseSteamHeaders(header http.Header) (*SteamScreenshot, error) {
+	steam := &SteamScreenshot{}
+	found := false
+	var err error
+
+	for name, field := range map[string]*string{
+		HeaderSteamCaption:  &steam.Caption,
+		HeaderSteamLocation: &steam.Location,
+	} {
+		if val := header.Get(name); val != "" {
+			*field, err = url.PathUnescape(val)
+			if err != nil || !utf8.ValidString(*field) {
+				return nil, fmt.Errorf("invalid %s header: %q", name, val)
+			}
+			*field = strings.TrimSpace(*field)
+			found = true
+		}
+	}
+
+	if len(steam.Caption) > MaxCaptionLength {
+		return nil, fmt.Errorf("%s too long; the limit is %d bytes", HeaderSteamCaption, MaxCaptionLength)
+	}
+
+	if val := header.Get(HeaderSteamCreated); val != "" {
+		steam.Created, err = time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", HeaderSteamCreated, err)
+		}
+		found = true
+	}
+
+	if val := header.Get(HeaderSteamPublishedFileId); val != "" {
+		if _, err = strconv.ParseUint(val, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s header: %q", HeaderSteamPublishedFileId, val)
+		}
+		steam.PublishedFileId = val
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return steam, nil
+}
+
+// verify checks the received file against what the uploader said it sent.
+func (info uploadInfo) verify(size int64, sum []byte) error {
+	if info.Size != -1 && info.Size != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, received %d", info.Size, size)
+	}
+
+	if info.Sha256 != nil && !bytes.Equal(info.Sha256, sum) {
+		return fmt.Errorf("sha256 mismatch: expected %x, received %x", info.Sha256, sum)
+	}
+
+	return nil
 }
 
 // checkApiKey returns True if the key is valid
@@ -161,6 +411,26 @@ type ApiError struct {
 	Message string
 }
 
+func sendJson(w http.ResponseWriter, data any) {
+	sendJsonStatus(w, http.StatusOK, data)
+}
+
+func sendJsonStatus(w http.ResponseWriter, code int, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println(err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "JSON Marshal error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(raw)
+}
+
 func sendApiError(w http.ResponseWriter, errmsg ApiError) {
 	encoded, err := json.Marshal(errmsg)
 	if err != nil {
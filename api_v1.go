@@ -0,0 +1,1050 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Public API.  See API.md for documentation.  Reads are open to everyone;
+// edits need the API key.  Anything returned from here is part of the v1
+// contract, so internal structures are never returned directly.
+
+type ApiGame struct {
+	AppId         string      `json:"appid"`
+	Name          string      `json:"name"`
+	Count         int         `json:"count"`
+	FirstCapture  *time.Time  `json:"first_capture,omitempty"`
+	LatestCapture *time.Time  `json:"latest_capture,omitempty"`
+	Urls          ApiGameUrls `json:"urls"`
+	AliasOf       string      `json:"alias_of,omitempty"` // primary game, if this is an alias
+
+	// From the store or an imported dump, if there is one.
+	Type        string   `json:"type,omitempty"`
+	Developers  []string `json:"developers,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+	ReleaseYear int      `json:"release_year,omitempty"`
+}
+
+type ApiGameUrls struct {
+	Gallery string `json:"gallery"`
+	Banner  string `json:"banner"`
+	Images  string `json:"images"`
+}
+
+type ApiImage struct {
+	AppId      string    `json:"appid"`
+	Filename   string    `json:"filename"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	CapturedAt time.Time `json:"captured_at"`
+	HDR        bool      `json:"hdr"`
+	Video      bool      `json:"video"`
+	Duration   float64   `json:"duration,omitempty"` // seconds, video only
+	Caption    string    `json:"caption"`
+	Tags       []string  `json:"tags"`
+	Favorite   bool      `json:"favorite"`
+	Location   string    `json:"location,omitempty"` // from Steam, if the game reported one
+
+	Urls ApiImageUrls `json:"urls"`
+}
+
+// ApiImageEdit is the body of a PATCH request.  Fields that are left out
+// aren't changed.
+type ApiImageEdit struct {
+	Caption  *string   `json:"caption"`
+	Tags     *[]string `json:"tags"`
+	Favorite *bool     `json:"favorite"`
+}
+
+const (
+	MaxCaptionLength = 1000
+	MaxTags          = 50
+)
+
+type ApiImageUrls struct {
+	Image     string `json:"image"` // the original file
+	Thumbnail string `json:"thumbnail"`
+	Preview   string `json:"preview,omitempty"` // SDR version of HDR images
+	Steam     string `json:"steam,omitempty"`   // Steam community page, if published there
+}
+
+type ApiImagePage struct {
+	Images []ApiImage `json:"images"`
+	Next   string     `json:"next,omitempty"`
+}
+
+func newApiImage(md Metadata) ApiImage {
+	img := ApiImage{
+		AppId:      md.AppId,
+		Filename:   md.Filename,
+		Width:      md.Width,
+		Height:     md.Height,
+		CapturedAt: md.CapturedAt,
+		HDR:        md.HDR,
+		Video:      md.Video,
+		Duration:   md.Duration,
+		Caption:    md.Caption,
+		Tags:       md.Tags,
+		Favorite:   md.Favorite,
+		Location:   md.Location,
+		Urls: ApiImageUrls{
+			Image:     md.Src,
+			Thumbnail: md.Thumb,
+			Steam:     md.SteamUrl,
+		},
+	}
+
+	if img.Tags == nil {
+		img.Tags = []string{}
+	}
+
+	if md.Original != "" {
+		img.Urls.Image = md.Original
+		img.Urls.Preview = md.Src
+	}
+	return img
+}
+
+// GET /api/v1/games
+func (s *Server) handler_api_v1_games(w http.ResponseWriter, r *http.Request) {
+	games := []ApiGame{}
+
+	for appid, sum := range s.ImageCache.Summaries() {
+		name, _ := s.getGameName(appid)
+		games = append(games, s.newApiGame(appid, name, sum))
+	}
+
+	slices.SortFunc(games, func(a, b ApiGame) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	sendJson(w, games)
+}
+
+func (s *Server) newApiGame(appid, name string, sum GameSummary) ApiGame {
+	game := ApiGame{
+		AppId: appid,
+		Name:  name,
+		Count: sum.Count,
+		Urls: ApiGameUrls{
+			Gallery: "/game/" + appid + "/",
+			Banner:  "/img/" + appid + "/banner.jpg",
+			Images:  "/api/v1/games/" + appid + "/images",
+		},
+	}
+
+	if primary := s.Aliases.Primary(appid); primary != appid {
+		game.AliasOf = primary
+	}
+
+	if sum.Count > 0 {
+		game.FirstCapture = &sum.FirstCapture
+		game.LatestCapture = &sum.LatestCapture
+	}
+
+	if info, ok := s.Games.Info(appid); ok {
+		game.Type = info.Type
+		game.Developers = info.Developers
+		game.Genres = info.Genres
+		game.ReleaseYear = info.ReleaseYear
+	}
+	return game
+}
+
+// MaxGameNames limits the number of names in a single PUT /api/v1/games/names.
+const MaxGameNames = 10000
+
+// PUT /api/v1/games/names
+//
+// Stores names for appids Steam doesn't know about, like shortcuts to
+// non-Steam games.  The body is an object of appids to names.  Overrides in
+// overrides.json still take priority.
+func (s *Server) handler_api_v1_game_names(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	names := GameIDs{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&names); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid request body: %s", err),
+		})
+		return
+	}
+
+	if len(names) > MaxGameNames {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("too many names; the limit is %d", MaxGameNames),
+		})
+		return
+	}
+
+	for appid, name := range names {
+		name = strings.TrimSpace(name)
+		if _, err := strconv.ParseUint(appid, 10, 64); err != nil || name == "" {
+			sendApiError(w, ApiError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("invalid name for appid %q", appid),
+			})
+			return
+		}
+		names[appid] = name
+	}
+
+	s.Games.Update(names)
+	if err := s.Games.Save(); err != nil {
+		fmt.Println("unable to save game list:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to save names",
+		})
+		return
+	}
+
+	sendJson(w, map[string]int{"updated": len(names)})
+}
+
+// PUT /api/v1/games/info
+//
+// Imports game details.  The body is an object of appids to either GameInfo
+// objects, as they're stored in games.cache, or responses from the store's
+// appdetails endpoint, so a dump of those can be imported as is.
+func (s *Server) handler_api_v1_game_info(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&raw); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid request body: %s", err),
+		})
+		return
+	}
+
+	if len(raw) > MaxGameNames {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("too many games; the limit is %d", MaxGameNames),
+		})
+		return
+	}
+
+	list := map[string]GameInfo{}
+	for appid, val := range raw {
+		info, ok, err := parseGameInfo(val)
+		if _, perr := strconv.ParseUint(appid, 10, 64); perr != nil || err != nil {
+			sendApiError(w, ApiError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("invalid info for appid %q", appid),
+			})
+			return
+		}
+
+		// Apps the store didn't know about.
+		if ok {
+			list[appid] = info
+		}
+	}
+
+	s.Games.UpdateInfo(list)
+	if err := s.Games.Save(); err != nil {
+		fmt.Println("unable to save game list:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to save game details",
+		})
+		return
+	}
+
+	sendJson(w, map[string]int{"updated": len(list)})
+}
+
+// parseGameInfo reads a GameInfo or an appdetails response.  ok is false for
+// unsuccessful appdetails responses.
+func parseGameInfo(raw json.RawMessage) (info GameInfo, ok bool, err error) {
+	app := storeApp{}
+	if err = json.Unmarshal(raw, &app); err == nil && app.Success != nil {
+		if !*app.Success {
+			return GameInfo{}, false, nil
+		}
+		info = app.gameInfo()
+	} else if err = json.Unmarshal(raw, &info); err != nil {
+		return GameInfo{}, false, err
+	}
+
+	info.Name = strings.TrimSpace(info.Name)
+	return info, true, nil
+}
+
+// PUT /api/v1/games/{appid}/banner
+//
+// Replaces the game's banner with a JPEG or PNG image.  Steam's banners are
+// 460x215.
+func (s *Server) handler_api_v1_set_banner(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	appid := r.PathValue("appid")
+	if _, err := strconv.ParseUint(appid, 10, 64); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid appid %q", appid),
+		})
+		return
+	}
+
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBannerSize))
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("unable to read banner: %s", err),
+		})
+		return
+	}
+
+	var ext string
+	switch http.DetectContentType(raw) {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	}
+
+	if _, _, err := image.DecodeConfig(bytes.NewReader(raw)); ext == "" || err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: "banners must be JPEG or PNG images",
+		})
+		return
+	}
+
+	if err = s.Banners.SetCustom(appid, ext, bytes.NewReader(raw)); err != nil {
+		fmt.Println(err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to save banner",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /api/v1/games/{appid}/banner
+//
+// Removes an uploaded banner.  Steam's banner is used again.
+func (s *Server) handler_api_v1_delete_banner(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	ok, err := s.Banners.RemoveCustom(r.PathValue("appid"))
+	if !ok {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "no custom banner",
+		})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to remove banner",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ApiOverride struct {
+	AppId string `json:"appid"`
+	Name  string `json:"name"`
+}
+
+// GET /api/v1/overrides
+func (s *Server) handler_api_v1_overrides(w http.ResponseWriter, r *http.Request) {
+	overrides := []ApiOverride{}
+	for _, ovr := range s.Overrides.List() {
+		overrides = append(overrides, ApiOverride{AppId: ovr.Appid, Name: ovr.Name})
+	}
+	sendJson(w, overrides)
+}
+
+// PUT /api/v1/overrides/{appid}
+func (s *Server) handler_api_v1_set_override(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	appid := r.PathValue("appid")
+	if _, err := strconv.ParseUint(appid, 10, 64); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid appid %q", appid),
+		})
+		return
+	}
+
+	body := struct {
+		Name string `json:"name"`
+	}{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid request body: %s", err),
+		})
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: "name is required",
+		})
+		return
+	}
+
+	created, err := s.Overrides.Set(appid, name)
+	if err != nil {
+		fmt.Println("unable to save overrides:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to save override",
+		})
+		return
+	}
+	s.Games.Set(appid, name)
+
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	sendJsonStatus(w, code, ApiOverride{AppId: appid, Name: name})
+}
+
+// DELETE /api/v1/overrides/{appid}
+//
+// The game goes back to the name from the other resolvers, which may take a
+// moment to look up.
+func (s *Server) handler_api_v1_delete_override(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	appid := r.PathValue("appid")
+	ok, err := s.Overrides.Delete(appid)
+	if !ok {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "override not found",
+		})
+		return
+	}
+	if err != nil {
+		fmt.Println("unable to save overrides:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to remove override",
+		})
+		return
+	}
+
+	s.Games.Delete(appid)
+	s.getGameName(appid)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ApiAlias struct {
+	AppId   string `json:"appid"`
+	Primary string `json:"primary"`
+
+	// Only in the response to a PUT that moved files.
+	Moved   []string `json:"moved,omitempty"`
+	Skipped []string `json:"skipped,omitempty"` // filenames already used by the primary
+}
+
+// GET /api/v1/aliases
+func (s *Server) handler_api_v1_aliases(w http.ResponseWriter, r *http.Request) {
+	aliases := []ApiAlias{}
+	for _, alias := range s.Aliases.List() {
+		aliases = append(aliases, ApiAlias{AppId: alias.Appid, Primary: alias.Primary})
+	}
+	sendJson(w, aliases)
+}
+
+// PUT /api/v1/aliases/{appid}
+//
+// Makes appid an alias of another game.  With "move" set, its files are moved
+// into the primary's directory as well.
+func (s *Server) handler_api_v1_set_alias(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	appid := r.PathValue("appid")
+	if _, err := strconv.ParseUint(appid, 10, 64); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid appid %q", appid),
+		})
+		return
+	}
+
+	body := struct {
+		Primary string `json:"primary"`
+		Move    bool   `json:"move"`
+	}{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid request body: %s", err),
+		})
+		return
+	}
+
+	if _, err := strconv.ParseUint(body.Primary, 10, 64); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid primary appid %q", body.Primary),
+		})
+		return
+	}
+
+	primary, created, err := s.Aliases.Set(appid, body.Primary)
+	if err != nil {
+		fmt.Println("unable to save aliases:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to save alias",
+		})
+		return
+	}
+	if primary == appid {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: "a game can't be an alias of itself",
+		})
+		return
+	}
+
+	alias := ApiAlias{AppId: appid, Primary: primary}
+	if body.Move {
+		alias.Moved, alias.Skipped, err = s.ImageCache.MoveImages(appid, primary)
+		if len(alias.Moved) > 0 {
+			s.Albums.MoveImages(appid, primary, alias.Moved)
+		}
+		if err != nil {
+			sendApiError(w, ApiError{
+				Code:    http.StatusInternalServerError,
+				Message: fmt.Sprintf("unable to move files after %d: %s", len(alias.Moved), err),
+			})
+			return
+		}
+	}
+
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	sendJsonStatus(w, code, alias)
+}
+
+// DELETE /api/v1/aliases/{appid}
+//
+// The alias gets its own tile again, unless its files were moved.
+func (s *Server) handler_api_v1_delete_alias(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	ok, err := s.Aliases.Delete(r.PathValue("appid"))
+	if !ok {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "alias not found",
+		})
+		return
+	}
+	if err != nil {
+		fmt.Println("unable to save aliases:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to remove alias",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/v1/games/{appid}/images
+//
+// Includes the images of the game's aliases, like the gallery does.  Asking
+// for an alias returns its primary's images.
+func (s *Server) handler_api_v1_game_images(w http.ResponseWriter, r *http.Request) {
+	appid := s.Aliases.Primary(r.PathValue("appid"))
+	if !s.hasGame(appid) {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "appid not found",
+		})
+		return
+	}
+	images := s.gameMetadata(appid)
+
+	items, next, err := paginate(images, compareMetadata, r.URL.Query().Get("cursor"), pageLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	page := ApiImagePage{Images: []ApiImage{}, Next: next}
+	for _, md := range items {
+		page.Images = append(page.Images, newApiImage(md))
+	}
+
+	sendJson(w, page)
+}
+
+// GET /api/v1/images/{appid}/{filename}
+func (s *Server) handler_api_v1_image(w http.ResponseWriter, r *http.Request) {
+	md, ok := s.ImageCache.GetImage(r.PathValue("appid"), r.PathValue("filename"))
+	if !ok {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "image not found",
+		})
+		return
+	}
+
+	sendJson(w, newApiImage(md))
+}
+
+// PATCH /api/v1/images/{appid}/{filename}
+func (s *Server) handler_api_v1_edit_image(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	edit := ApiImageEdit{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&edit); err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid request body: %s", err),
+		})
+		return
+	}
+
+	var tags []string
+	if edit.Tags != nil {
+		tags = normalizeTags(*edit.Tags)
+		if len(tags) > MaxTags {
+			sendApiError(w, ApiError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("too many tags; the limit is %d", MaxTags),
+			})
+			return
+		}
+	}
+
+	if edit.Caption != nil && len(*edit.Caption) > MaxCaptionLength {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("caption too long; the limit is %d bytes", MaxCaptionLength),
+		})
+		return
+	}
+
+	md, ok, err := s.ImageCache.EditImage(r.PathValue("appid"), r.PathValue("filename"), func(meta *ImageMeta) {
+		if edit.Caption != nil {
+			meta.Caption = strings.TrimSpace(*edit.Caption)
+		}
+		if edit.Tags != nil {
+			meta.Tags = tags
+		}
+		if edit.Favorite != nil {
+			meta.Favorite = *edit.Favorite
+		}
+	})
+
+	if !ok {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "image not found",
+		})
+		return
+	}
+
+	if err != nil {
+		fmt.Println("unable to save image cache:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to save image",
+		})
+		return
+	}
+
+	sendJson(w, newApiImage(md))
+}
+
+// normalizeTags trims whitespace and drops empty and duplicate tags.  Tags
+// are compared case-insensitively and the first spelling wins.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+type ApiSearchResults struct {
+	Games  []ApiGame  `json:"games"`
+	Images []ApiImage `json:"images"`
+}
+
+// GET /api/v1/search?q=
+func (s *Server) handler_api_v1_search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: "missing query",
+		})
+		return
+	}
+
+	limit := min(pageLimit(r.URL.Query().Get("limit")), MaxPageSize)
+	results := s.Search(query, limit)
+	summaries := s.ImageCache.Summaries()
+
+	ret := ApiSearchResults{Games: []ApiGame{}, Images: []ApiImage{}}
+	for _, appid := range results.Games {
+		ret.Games = append(ret.Games, s.newApiGame(appid, s.Games.Get(appid), summaries[appid]))
+	}
+	for _, md := range results.Images {
+		ret.Images = append(ret.Images, newApiImage(md))
+	}
+
+	sendJson(w, ret)
+}
+
+type ApiImageRef struct {
+	AppId    string `json:"appid"`
+	Filename string `json:"filename"`
+}
+
+type ApiAlbum struct {
+	Id          string       `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Count       int          `json:"count"`
+	Cover       *ApiImageRef `json:"cover,omitempty"`
+	Created     time.Time    `json:"created"`
+	Updated     time.Time    `json:"updated"`
+	Urls        ApiAlbumUrls `json:"urls"`
+
+	// Only included when requesting a single album.
+	Images []ApiImage `json:"images,omitempty"`
+}
+
+type ApiAlbumUrls struct {
+	Gallery   string `json:"gallery"`
+	Album     string `json:"album"`
+	Thumbnail string `json:"thumbnail,omitempty"` // of the cover image
+}
+
+// ApiAlbumEdit is the body for creating and editing albums.  Fields that are
+// left out aren't changed.  Cover can be set to null to use the first image.
+type ApiAlbumEdit struct {
+	Name        *string         `json:"name"`
+	Description *string         `json:"description"`
+	Cover       json.RawMessage `json:"cover"`
+	Images      *[]ApiImageRef  `json:"images"`
+}
+
+func (s *Server) newApiAlbum(album Album, images []Metadata) ApiAlbum {
+	ret := ApiAlbum{
+		Id:          album.Id,
+		Name:        album.Name,
+		Description: album.Description,
+		Count:       len(images),
+		Created:     album.Created,
+		Updated:     album.Updated,
+		Urls: ApiAlbumUrls{
+			Gallery: "/album/" + album.Id,
+			Album:   "/api/v1/albums/" + album.Id,
+		},
+	}
+
+	if album.Cover != nil {
+		ret.Cover = &ApiImageRef{AppId: album.Cover.AppId, Filename: album.Cover.Filename}
+	}
+	if cover, ok := album.CoverImage(); ok {
+		ret.Urls.Thumbnail = "/thumb/" + cover.AppId + "/" + cover.Filename
+	}
+	return ret
+}
+
+// readAlbumEdit decodes the request body and checks that every image it
+// references exists.
+func (s *Server) readAlbumEdit(w http.ResponseWriter, r *http.Request) (ApiAlbumEdit, error) {
+	edit := ApiAlbumEdit{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&edit); err != nil {
+		return edit, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	if edit.Name != nil {
+		name := strings.TrimSpace(*edit.Name)
+		if name == "" {
+			return edit, fmt.Errorf("name can't be empty")
+		}
+		edit.Name = &name
+	}
+
+	if edit.Images != nil {
+		for _, ref := range *edit.Images {
+			if _, ok := s.ImageCache.GetImage(ref.AppId, ref.Filename); !ok {
+				return edit, fmt.Errorf("image not found: %s/%s", ref.AppId, ref.Filename)
+			}
+		}
+	}
+	return edit, nil
+}
+
+// apply copies the edit onto album.
+func (edit ApiAlbumEdit) apply(album *Album) error {
+	if edit.Name != nil {
+		album.Name = *edit.Name
+	}
+	if edit.Description != nil {
+		album.Description = *edit.Description
+	}
+	if edit.Images != nil {
+		album.Images = []AlbumImage{}
+		for _, ref := range *edit.Images {
+			album.Images = append(album.Images, AlbumImage{AppId: ref.AppId, Filename: ref.Filename})
+		}
+	}
+
+	if len(edit.Cover) > 0 {
+		var cover *ApiImageRef
+		if err := json.Unmarshal(edit.Cover, &cover); err != nil {
+			return fmt.Errorf("invalid cover: %w", err)
+		}
+
+		album.Cover = nil
+		if cover != nil {
+			album.Cover = &AlbumImage{AppId: cover.AppId, Filename: cover.Filename}
+		}
+	}
+
+	// The cover has to be one of the album's images.  Replacing the images
+	// without also setting a cover drops a cover that was removed.
+	if album.Cover != nil && !slices.Contains(album.Images, *album.Cover) {
+		if len(edit.Cover) > 0 {
+			return fmt.Errorf("cover isn't in the album")
+		}
+		album.Cover = nil
+	}
+	return nil
+}
+
+// GET /api/v1/albums
+func (s *Server) handler_api_v1_albums(w http.ResponseWriter, r *http.Request) {
+	albums := []ApiAlbum{}
+	for _, album := range s.Albums.List() {
+		albums = append(albums, s.newApiAlbum(album, s.albumMetadata(album)))
+	}
+	sendJson(w, albums)
+}
+
+// GET /api/v1/albums/{id}
+func (s *Server) handler_api_v1_album(w http.ResponseWriter, r *http.Request) {
+	album, ok := s.Albums.Get(r.PathValue("id"))
+	if !ok {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "album not found",
+		})
+		return
+	}
+
+	s.sendAlbum(w, http.StatusOK, album)
+}
+
+// sendAlbum sends an album along with its images.
+func (s *Server) sendAlbum(w http.ResponseWriter, code int, album Album) {
+	images := s.albumMetadata(album)
+	ret := s.newApiAlbum(album, images)
+	ret.Images = []ApiImage{}
+	for _, md := range images {
+		ret.Images = append(ret.Images, newApiImage(md))
+	}
+	sendJsonStatus(w, code, ret)
+}
+
+// POST /api/v1/albums
+func (s *Server) handler_api_v1_create_album(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	edit, err := s.readAlbumEdit(w, r)
+	if err == nil && edit.Name == nil {
+		err = fmt.Errorf("name is required")
+	}
+
+	album := Album{Images: []AlbumImage{}}
+	if err == nil {
+		err = edit.apply(&album)
+	}
+
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	album, err = s.Albums.Create(album)
+	if err != nil {
+		fmt.Println("unable to create album:", err)
+		sendApiError(w, ApiError{
+			Code:    http.StatusInternalServerError,
+			Message: "unable to create album",
+		})
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/albums/"+album.Id)
+	s.sendAlbum(w, http.StatusCreated, album)
+}
+
+// PATCH /api/v1/albums/{id}
+func (s *Server) handler_api_v1_edit_album(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	edit, err := s.readAlbumEdit(w, r)
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	s.updateAlbum(w, r.PathValue("id"), edit.apply)
+}
+
+// POST /api/v1/albums/{id}/images appends images to the end of an album.
+// Images that are already in it are left where they are.
+func (s *Server) handler_api_v1_add_album_images(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	edit, err := s.readAlbumEdit(w, r)
+	if err == nil && (edit.Images == nil || edit.Name != nil || edit.Description != nil || len(edit.Cover) > 0) {
+		err = fmt.Errorf("only images can be given")
+	}
+
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	s.updateAlbum(w, r.PathValue("id"), func(album *Album) error {
+		for _, ref := range *edit.Images {
+			img := AlbumImage{AppId: ref.AppId, Filename: ref.Filename}
+			if !slices.Contains(album.Images, img) {
+				album.Images = append(album.Images, img)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Server) updateAlbum(w http.ResponseWriter, id string, edit func(album *Album) error) {
+	album, found, err := s.Albums.Update(id, edit)
+	if !found {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "album not found",
+		})
+		return
+	}
+
+	if err != nil {
+		sendApiError(w, ApiError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	s.sendAlbum(w, http.StatusOK, album)
+}
+
+// DELETE /api/v1/albums/{id}
+func (s *Server) handler_api_v1_delete_album(w http.ResponseWriter, r *http.Request) {
+	if !s.checkApiKey(w, r) {
+		return
+	}
+
+	if !s.Albums.Delete(r.PathValue("id")) {
+		sendApiError(w, ApiError{
+			Code:    http.StatusNotFound,
+			Message: "album not found",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handler_api_openapi serves the OpenAPI description of every endpoint.
+func (s *Server) handler_api_openapi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFileFS(w, r, embeddedContent, "openapi.json")
+}
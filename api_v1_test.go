@@ -0,0 +1,98 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getJson(t *testing.T, handler http.HandlerFunc, req *http.Request, v any) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %s", err, w.Body)
+		}
+	}
+	return w.Code
+}
+
+func TestApiV1Read(t *testing.T) {
+	games := newTestGameList(t)
+	games.Set("220", "Half-Life 2")
+	s := newTestServer(t, newFakeSteam(t), games, Settings{NameResolvers: []string{ResolverCache}})
+
+	now := time.Now()
+	s.ImageCache = NewGameImages()
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{
+		"a.jpg": {Width: 1920, Height: 1080, CapturedAt: now.Add(-time.Hour), Tags: []string{"city"}},
+		"b.jpg": {Width: 1920, Height: 1080, CapturedAt: now, Favorite: true},
+	}
+	s.ImageCache.Games["221"] = map[string]*ImageMeta{
+		"c.jpg": {Width: 800, Height: 600, CapturedAt: now.Add(-2 * time.Hour)},
+	}
+	if _, _, err := s.Aliases.Set("221", "220"); err != nil {
+		t.Fatal(err)
+	}
+
+	list := []ApiGame{}
+	if code := getJson(t, s.handler_api_v1_games, httptest.NewRequest("GET", "/api/v1/games", nil), &list); code != 200 {
+		t.Fatalf("games: %d", code)
+	}
+	found := map[string]ApiGame{}
+	for _, game := range list {
+		found[game.AppId] = game
+	}
+	if game := found["220"]; game.Name != "Half-Life 2" || game.Count != 2 || !game.LatestCapture.Equal(now) || game.Urls.Images != "/api/v1/games/220/images" {
+		t.Errorf("unexpected game: %+v", game)
+	}
+	if game := found["221"]; game.AliasOf != "220" || game.Count != 1 {
+		t.Errorf("unexpected alias: %+v", game)
+	}
+
+	// Asking for the alias returns every image of the primary, oldest first.
+	req := httptest.NewRequest("GET", "/api/v1/games/221/images?limit=2", nil)
+	req.SetPathValue("appid", "221")
+	page := ApiImagePage{}
+	if code := getJson(t, s.handler_api_v1_game_images, req, &page); code != 200 {
+		t.Fatalf("images: %d", code)
+	}
+	if len(page.Images) != 2 || page.Images[0].Filename != "c.jpg" || page.Images[1].Filename != "a.jpg" || page.Next == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	if img := page.Images[1]; img.Width != 1920 || img.Height != 1080 || len(img.Tags) != 1 || img.Urls.Image != "/img/220/a.jpg" {
+		t.Errorf("unexpected image: %+v", img)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/games/220/images?limit=2&cursor="+page.Next, nil)
+	req.SetPathValue("appid", "220")
+	page = ApiImagePage{}
+	if code := getJson(t, s.handler_api_v1_game_images, req, &page); code != 200 {
+		t.Fatalf("images: %d", code)
+	}
+	if len(page.Images) != 1 || page.Images[0].Filename != "b.jpg" || page.Next != "" {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/games/999/images", nil)
+	req.SetPathValue("appid", "999")
+	if code := getJson(t, s.handler_api_v1_game_images, req, &page); code != http.StatusNotFound {
+		t.Errorf("unknown game: expected 404, got %d", code)
+	}
+
+	for filename, expect := range map[string]int{"b.jpg": 200, "missing.jpg": 404} {
+		req = httptest.NewRequest("GET", "/api/v1/images/220/"+filename, nil)
+		req.SetPathValue("appid", "220")
+		req.SetPathValue("filename", filename)
+		img := ApiImage{}
+		if code := getJson(t, s.handler_api_v1_image, req, &img); code != expect {
+			t.Errorf("%s: expected %d, got %d", filename, expect, code)
+		} else if code == 200 && (!img.Favorite || img.Tags == nil) {
+			t.Errorf("unexpected image: %+v", img)
+		}
+	}
+}
@@ -0,0 +1,686 @@
+package steamscreenshots
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Library archives hold everything needed to move a server: the original
+// images, the image index with its user data, the game name cache, albums,
+// appid overrides and banners.  Thumbnails and previews aren't included;
+// they're regenerated by the first scan after importing.
+//
+// Layout of the tar file:
+//
+//	images/<appid>/<filename>
+//	image.cache
+//	games.cache
+//	albums.json
+//	overrides.json
+//	aliases.json
+//	banners/<filename>	downloaded and uploaded banners, and banners.json
+//	manifest.json
+//
+// The manifest comes last and has the size and SHA-256 of every other file.
+// Imports are staged and checked against it before anything is changed.
+
+const (
+	archiveVersion  = 1
+	archiveManifest = "manifest.json"
+	archiveImages   = "images/"
+	archiveBanners  = BannerDirectory + "/"
+	archiveOverride = OverridesFile
+	archiveAliases  = AliasesFile
+)
+
+type ArchiveManifest struct {
+	Version int
+	Created time.Time
+	Files   map[string]ArchiveFile
+}
+
+type ArchiveFile struct {
+	Size   int64
+	Sha256 string
+}
+
+type archiveWriter struct {
+	tw       *tar.Writer
+	manifest ArchiveManifest
+}
+
+func (aw *archiveWriter) add(name string, modTime time.Time, size int64, r io.Reader) error {
+	err := aw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(aw.tw, hash), r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	aw.manifest.Files[name] = ArchiveFile{Size: n, Sha256: hex.EncodeToString(hash.Sum(nil))}
+	return nil
+}
+
+func (aw *archiveWriter) addFile(name, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return aw.add(name, info.ModTime(), info.Size(), file)
+}
+
+func (aw *archiveWriter) addBytes(name string, data []byte) error {
+	return aw.add(name, time.Now(), int64(len(data)), bytes.NewReader(data))
+}
+
+// OpenLibrary loads what Export and Import need: the settings, overrides and
+// aliases.  Unlike NewServer it doesn't change anything on disk.  Overrides
+// that are still in the settings file are used but not moved, and the other
+// stores are read by Export and Import themselves.
+func OpenLibrary(settingsFile string) (*Server, error) {
+	s := &Server{SettingsFile: settingsFile}
+	if err := s.readSettings(settingsFile); err != nil {
+		return nil, fmt.Errorf("Error loading settings: %w", err)
+	}
+
+	var err error
+	s.Overrides, err = LoadOverrides(OverridesFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !exists(OverridesFile) {
+		for _, ovr := range s.settings.AppidOverrides {
+			s.Overrides.names[ovr.Appid] = ovr.Name
+		}
+	}
+
+	s.Aliases, err = LoadAliases(AliasesFile)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Export writes the library to w as a tar archive.  Only images in the
+// image index are included.
+func (s *Server) Export(w io.Writer) error {
+	images, err := LoadImageCache(ImageCacheFile, s.settings.ImageDirectory)
+	if err != nil {
+		return fmt.Errorf("error loading image cache: %w", err)
+	}
+
+	aw := &archiveWriter{
+		tw: tar.NewWriter(w),
+		manifest: ArchiveManifest{
+			Version: archiveVersion,
+			Created: time.Now(),
+			Files:   make(map[string]ArchiveFile),
+		},
+	}
+
+	for appid, game := range images.Games {
+		for filename := range game {
+			err = aw.addFile(archiveImages+appid+"/"+filename, filepath.Join(s.settings.ImageDirectory, appid, filename))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range []string{ImageCacheFile, GameCacheFile, AlbumsFile} {
+		if !exists(name) {
+			continue
+		}
+		if err = aw.addFile(name, name); err != nil {
+			return err
+		}
+	}
+
+	overrides, err := json.MarshalIndent(s.Overrides.List(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = aw.addBytes(archiveOverride, overrides); err != nil {
+		return err
+	}
+
+	aliases, err := json.MarshalIndent(s.Aliases.List(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = aw.addBytes(archiveAliases, aliases); err != nil {
+		return err
+	}
+
+	banners, err := os.ReadDir(BannerDirectory)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, banner := range banners {
+		// Skip downloads in progress.
+		if banner.IsDir() || strings.HasPrefix(banner.Name(), ".") {
+			continue
+		}
+		err = aw.addFile(archiveBanners+banner.Name(), filepath.Join(BannerDirectory, banner.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(aw.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = aw.addBytes(archiveManifest, manifest); err != nil {
+		return err
+	}
+
+	return aw.tw.Close()
+}
+
+type ImportOptions struct {
+	DryRun    bool // Only report what would happen
+	Overwrite bool // Replace existing files and entries that differ
+}
+
+// ImportReport lists what happened, or would happen, to each file and entry
+// in an archive.  Cache entries are listed as eg "games.cache: 220".
+type ImportReport struct {
+	Added     []string
+	Replaced  []string
+	Unchanged []string
+	Conflicts []string // Differ from the existing copy and were skipped
+	Invalid   []string // Failed the manifest check
+}
+
+// OK returns whether the archive passed the integrity checks.
+func (r *ImportReport) OK() bool {
+	return len(r.Invalid) == 0
+}
+
+func (r *ImportReport) Print(w io.Writer) {
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{"Invalid", r.Invalid},
+		{"Conflicts (skipped)", r.Conflicts},
+		{"Replaced", r.Replaced},
+		{"Added", r.Added},
+	}
+
+	for _, sec := range sections {
+		if len(sec.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", sec.title)
+		for _, item := range sec.items {
+			fmt.Fprintf(w, "    %s\n", item)
+		}
+	}
+
+	fmt.Fprintf(w, "%d added, %d replaced, %d unchanged, %d conflicts, %d invalid\n",
+		len(r.Added), len(r.Replaced), len(r.Unchanged), len(r.Conflicts), len(r.Invalid))
+}
+
+// record sorts a file or entry into the report based on whether it already
+// exists and matches, and returns whether it should be written.
+func (r *ImportReport) record(name string, existing, same, overwrite bool) bool {
+	switch {
+	case !existing:
+		r.Added = append(r.Added, name)
+		return true
+	case same:
+		r.Unchanged = append(r.Unchanged, name)
+		return false
+	case overwrite:
+		r.Replaced = append(r.Replaced, name)
+		return true
+	default:
+		r.Conflicts = append(r.Conflicts, name)
+		return false
+	}
+}
+
+// Import reads an archive made by Export into this server's directories.
+// The server shouldn't be running while importing.  Nothing is changed if
+// the archive fails its integrity checks, or with opts.DryRun.
+func (s *Server) Import(r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	staging, err := os.MkdirTemp("", "steam-screenshots-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	report := &ImportReport{}
+	manifest, staged, err := stageArchive(r, staging, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.OK() {
+		return report, nil
+	}
+
+	imp := &importer{
+		server:  s,
+		staging: staging,
+		opts:    opts,
+		report:  report,
+	}
+
+	for _, name := range staged {
+		switch {
+		case strings.HasPrefix(name, archiveImages):
+			err = imp.copyFile(name, filepath.Join(s.settings.ImageDirectory, filepath.FromSlash(strings.TrimPrefix(name, archiveImages))), manifest.Files[name])
+		case strings.HasPrefix(name, archiveBanners):
+			err = imp.copyFile(name, filepath.FromSlash(name), manifest.Files[name])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, step := range []func() error{imp.images, imp.games, imp.albums, imp.overrides, imp.aliases} {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// stageArchive extracts the archive to dir and checks it against its
+// manifest.  Problems with individual files are added to report.Invalid.
+// The staged files are returned sorted.
+func stageArchive(r io.Reader, dir string, report *ImportReport) (*ArchiveManifest, []string, error) {
+	tr := tar.NewReader(r)
+	staged := map[string]ArchiveFile{}
+	var manifest *ArchiveManifest
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("error reading archive: %w", err)
+		}
+
+		// Directories are implied by the file paths.  Other tools add them
+		// when an archive is repacked.
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || !validArchiveName(name) {
+			report.Invalid = append(report.Invalid, name+": unexpected entry")
+			continue
+		}
+
+		if name == archiveManifest {
+			manifest = &ArchiveManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, nil, err
+		}
+
+		file, err := os.Create(target)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(file, hash), tr)
+		file.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s: %w", name, err)
+		}
+
+		// Keep the original mtime; it's the fallback capture time.
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return nil, nil, err
+		}
+
+		staged[name] = ArchiveFile{Size: size, Sha256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("archive has no manifest")
+	}
+
+	if manifest.Version != archiveVersion {
+		return nil, nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	names := []string{}
+	for name, got := range staged {
+		want, ok := manifest.Files[name]
+		switch {
+		case !ok:
+			report.Invalid = append(report.Invalid, name+": not in manifest")
+		case want != got:
+			report.Invalid = append(report.Invalid, name+": checksum mismatch")
+		default:
+			names = append(names, name)
+		}
+	}
+
+	for name := range manifest.Files {
+		if _, ok := staged[name]; !ok {
+			report.Invalid = append(report.Invalid, name+": missing from archive")
+		}
+	}
+
+	slices.Sort(names)
+	slices.Sort(report.Invalid)
+	return manifest, names, nil
+}
+
+// validArchiveName only allows the paths Export writes.
+func validArchiveName(name string) bool {
+	if name != path.Clean(name) || path.IsAbs(name) || strings.Contains(name, "\\") {
+		return false
+	}
+
+	switch name {
+	case archiveManifest, ImageCacheFile, GameCacheFile, AlbumsFile, archiveOverride, archiveAliases:
+		return true
+	}
+
+	if rest, ok := strings.CutPrefix(name, archiveImages); ok {
+		parts := strings.Split(rest, "/")
+		return len(parts) == 2 && parts[0] != ".." && parts[1] != ".." && parts[0] != "" && parts[1] != ""
+	}
+
+	if rest, ok := strings.CutPrefix(name, archiveBanners); ok {
+		return rest != "" && rest != ".." && !strings.Contains(rest, "/")
+	}
+	return false
+}
+
+type importer struct {
+	server  *Server
+	staging string
+	opts    ImportOptions
+	report  *ImportReport
+}
+
+func (imp *importer) staged(name string) string {
+	return filepath.Join(imp.staging, filepath.FromSlash(name))
+}
+
+// copyFile moves a staged file to target unless it conflicts.
+func (imp *importer) copyFile(name, target string, want ArchiveFile) error {
+	existing := exists(target)
+	same := false
+	if existing {
+		sum, err := fileSha256(target)
+		if err != nil {
+			return err
+		}
+		same = sum == want.Sha256
+	}
+
+	if !imp.report.record(name, existing, same, imp.opts.Overwrite) || imp.opts.DryRun {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return copyFile(imp.staged(name), target)
+}
+
+// images merges the archive's image index into the local one.  Entries for
+// images that already exist locally are only replaced with Overwrite, which
+// is how captions, tags and favorites come along.
+func (imp *importer) images() error {
+	if !exists(imp.staged(ImageCacheFile)) {
+		return nil
+	}
+
+	incoming, err := LoadImageCache(imp.staged(ImageCacheFile), imp.server.settings.ImageDirectory)
+	if err != nil {
+		return err
+	}
+
+	local, err := LoadImageCache(ImageCacheFile, imp.server.settings.ImageDirectory)
+	if err != nil {
+		return err
+	}
+
+	for appid, game := range incoming.Games {
+		if local.Games[appid] == nil {
+			local.Games[appid] = make(map[string]*ImageMeta)
+		}
+
+		for filename, meta := range game {
+			current, existing := local.Games[appid][filename]
+			same := existing && sameJson(current, meta)
+			if imp.report.record(ImageCacheFile+": "+appid+"/"+filename, existing, same, imp.opts.Overwrite) {
+				local.Games[appid][filename] = meta
+			}
+		}
+	}
+
+	if imp.opts.DryRun {
+		return nil
+	}
+	return local.Save()
+}
+
+func (imp *importer) games() error {
+	if !exists(imp.staged(GameCacheFile)) {
+		return nil
+	}
+
+	incoming, err := LoadGameList(imp.staged(GameCacheFile))
+	if err != nil {
+		return err
+	}
+
+	local, err := LoadGameList(GameCacheFile)
+	if err != nil {
+		return err
+	}
+
+	current := local.GetInfoMap()
+	for appid, info := range incoming.GetInfoMap() {
+		localInfo, existing := current[appid]
+		if imp.report.record(GameCacheFile+": "+appid, existing, reflect.DeepEqual(localInfo, info), imp.opts.Overwrite) {
+			local.SetInfo(appid, info)
+		}
+	}
+
+	if imp.opts.DryRun {
+		return nil
+	}
+	return local.Save()
+}
+
+func (imp *importer) albums() error {
+	if !exists(imp.staged(AlbumsFile)) {
+		return nil
+	}
+
+	incoming, err := LoadAlbums(imp.staged(AlbumsFile))
+	if err != nil {
+		return err
+	}
+
+	local, err := LoadAlbums(AlbumsFile)
+	if err != nil {
+		return err
+	}
+
+	for id, album := range incoming.albums {
+		current, existing := local.albums[id]
+		same := existing && sameJson(current, album)
+		if imp.report.record(AlbumsFile+": "+id, existing, same, imp.opts.Overwrite) {
+			local.albums[id] = album
+		}
+	}
+
+	if imp.opts.DryRun {
+		return nil
+	}
+
+	local.m.Lock()
+	defer local.m.Unlock()
+	if err = local.write(); err != nil {
+		return fmt.Errorf("unable to save albums: %w", err)
+	}
+	return nil
+}
+
+// overrides merges the archive's appid overrides into overrides.json.
+func (imp *importer) overrides() error {
+	raw, err := os.ReadFile(imp.staged(archiveOverride))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	incoming := []AppidOverride{}
+	if err = json.Unmarshal(raw, &incoming); err != nil {
+		return fmt.Errorf("invalid %s: %w", archiveOverride, err)
+	}
+
+	for _, ovr := range incoming {
+		name, existing, _ := imp.server.Overrides.ResolveName(ovr.Appid)
+		same := existing && name == ovr.Name
+		if !imp.report.record(archiveOverride+": "+ovr.Appid, existing, same, imp.opts.Overwrite) || imp.opts.DryRun {
+			continue
+		}
+
+		if _, err = imp.server.Overrides.Set(ovr.Appid, ovr.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aliases merges the archive's aliases into aliases.json.
+func (imp *importer) aliases() error {
+	raw, err := os.ReadFile(imp.staged(archiveAliases))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	incoming := []GameAlias{}
+	if err = json.Unmarshal(raw, &incoming); err != nil {
+		return fmt.Errorf("invalid %s: %w", archiveAliases, err)
+	}
+
+	for _, alias := range incoming {
+		primary := imp.server.Aliases.Primary(alias.Appid)
+		existing := primary != alias.Appid
+		same := existing && primary == alias.Primary
+		if !imp.report.record(archiveAliases+": "+alias.Appid, existing, same, imp.opts.Overwrite) || imp.opts.DryRun {
+			continue
+		}
+
+		if _, _, err = imp.server.Aliases.Set(alias.Appid, alias.Primary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameJson(a, b any) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+func fileSha256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyFile copies src to dst through a temporary file so dst is never left
+// half written.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".import-*")
+	if err != nil {
+		return err
+	}
+	tmpname := out.Name()
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpname, 0644)
+	}
+	if err == nil {
+		err = os.Chtimes(tmpname, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpname, dst)
+	}
+
+	if err != nil {
+		os.Remove(tmpname)
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+package steamscreenshots
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inDir runs the rest of the test from dir, as the library's files are kept
+// in the working directory.
+func inDir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestLibrary makes a library in a new directory.  Its overrides are
+// still in the settings file, like a library from before overrides.json.
+func newTestLibrary(t *testing.T, caption string) string {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"settings.json":    `{"ImageDirectory": "images", "AppidOverrides": [{"id": "400", "name": "Portal"}]}`,
+		"images/220/a.jpg": "a",
+		ImageCacheFile:     `{"Games": {"220": {"a.jpg": {"Caption": "` + caption + `"}}}}`,
+		GameCacheFile:      `{"220": {"Name": "Half-Life 2"}}`,
+		AlbumsFile:         `{"abc": {"Id": "abc", "Name": "Best", "Images": [{"AppId": "220", "Filename": "a.jpg"}]}}`,
+		AliasesFile:        `[{"id": "221", "primary": "220"}]`,
+		"banners/220.jpg":  "banner",
+	})
+	return dir
+}
+
+// snapshot returns the contents of every file under dir, and every
+// directory with a trailing slash.
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			files[rel+"/"] = ""
+			return nil
+		}
+
+		raw, err := os.ReadFile(path)
+		files[rel] = string(raw)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func exportLibrary(t *testing.T, dir string) []byte {
+	t.Helper()
+	inDir(t, dir)
+
+	lib, err := OpenLibrary("settings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = lib.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportDryRun(t *testing.T) {
+	src := newTestLibrary(t, "from the archive")
+	before := snapshot(t, src)
+	archive := exportLibrary(t, src)
+	if after := snapshot(t, src); !maps.Equal(before, after) {
+		t.Errorf("export changed the library:\n%v\n%v", before, after)
+	}
+
+	dst := newTestLibrary(t, "local")
+	if err := os.RemoveAll(filepath.Join(dst, BannerDirectory)); err != nil {
+		t.Fatal(err)
+	}
+	before = snapshot(t, dst)
+
+	inDir(t, dst)
+	lib, err := OpenLibrary("settings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := lib.Import(bytes.NewReader(archive), ImportOptions{DryRun: true, Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || !slices.Contains(report.Added, "banners/220.jpg") || !slices.Contains(report.Replaced, ImageCacheFile+": 220/a.jpg") {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if after := snapshot(t, dst); !maps.Equal(before, after) {
+		t.Errorf("dry run changed the library:\n%v\n%v", before, after)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	archive := exportLibrary(t, newTestLibrary(t, "from the archive"))
+
+	dst := t.TempDir()
+	writeFiles(t, dst, map[string]string{"settings.json": `{"ImageDirectory": "images"}`})
+	inDir(t, dst)
+
+	lib, err := OpenLibrary("settings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := lib.Import(bytes.NewReader(archive), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Added) == 0 || len(report.Conflicts) > 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	files := snapshot(t, dst)
+	if files["images/220/a.jpg"] != "a" || files["banners/220.jpg"] != "banner" {
+		t.Errorf("files weren't imported: %v", files)
+	}
+
+	images, err := LoadImageCache(ImageCacheFile, "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md, ok := images.GetImage("220", "a.jpg"); !ok || md.Caption != "from the archive" {
+		t.Errorf("image index wasn't imported: %+v", md)
+	}
+
+	games, err := LoadGameList(GameCacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := games.Lookup("220"); name != "Half-Life 2" {
+		t.Errorf("game name wasn't imported: %q", name)
+	}
+
+	albums, err := LoadAlbums(AlbumsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if album, ok := albums.Get("abc"); !ok || len(album.Images) != 1 {
+		t.Errorf("album wasn't imported: %+v", album)
+	}
+
+	// The source's overrides were still in its settings file.
+	overrides, err := LoadOverrides(OverridesFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok, _ := overrides.ResolveName("400"); !ok || name != "Portal" {
+		t.Errorf("override wasn't imported: %q", name)
+	}
+
+	aliases, err := LoadAliases(AliasesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primary := aliases.Primary("221"); primary != "220" {
+		t.Errorf("alias wasn't imported: %q", primary)
+	}
+
+	// Importing the same archive again changes nothing.
+	lib, err = OpenLibrary("settings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = lib.Import(bytes.NewReader(archive), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added)+len(report.Replaced)+len(report.Conflicts) > 0 || len(report.Unchanged) == 0 {
+		t.Errorf("second import wasn't a no-op: %+v", report)
+	}
+}
+
+// buildArchive writes files the way Export does.  tamper can change the
+// manifest before it's added.
+func buildArchive(t *testing.T, files map[string]string, tamper func(manifest *ArchiveManifest)) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	aw := &archiveWriter{
+		tw:       tar.NewWriter(buf),
+		manifest: ArchiveManifest{Version: archiveVersion, Created: time.Now(), Files: make(map[string]ArchiveFile)},
+	}
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := aw.addBytes(name, []byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tamper != nil {
+		tamper(&aw.manifest)
+	}
+	manifest, err := json.Marshal(aw.manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = aw.addBytes(archiveManifest, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err = aw.tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// importInvalid imports an archive that should fail its checks, and returns
+// the invalid entries.  Nothing may change.
+func importInvalid(t *testing.T, archive []byte) []string {
+	t.Helper()
+
+	dst := newTestLibrary(t, "local")
+	before := snapshot(t, dst)
+	inDir(t, dst)
+
+	lib, err := OpenLibrary("settings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := lib.Import(bytes.NewReader(archive), ImportOptions{Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Errorf("archive passed its checks: %+v", report)
+	}
+
+	if after := snapshot(t, dst); !maps.Equal(before, after) {
+		t.Errorf("invalid archive changed the library:\n%v\n%v", before, after)
+	}
+	return report.Invalid
+}
+
+func TestImportTamperedManifest(t *testing.T) {
+	archive := buildArchive(t, map[string]string{
+		"images/220/a.jpg": "a",
+		"images/220/b.jpg": "b",
+	}, func(manifest *ArchiveManifest) {
+		file := manifest.Files["images/220/a.jpg"]
+		file.Sha256 = strings.Repeat("0", len(file.Sha256))
+		manifest.Files["images/220/a.jpg"] = file
+	})
+
+	invalid := importInvalid(t, archive)
+	if !slices.Equal(invalid, []string{"images/220/a.jpg: checksum mismatch"}) {
+		t.Errorf("unexpected invalid entries: %v", invalid)
+	}
+}
+
+func TestImportUnsafePaths(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "evil.jpg")
+	names := []string{
+		"../evil.jpg",
+		"images/../../evil.jpg",
+		"images/220/../../../evil.jpg",
+		filepath.ToSlash(outside),
+	}
+
+	files := map[string]string{}
+	for _, name := range names {
+		files[name] = "evil"
+	}
+
+	invalid := importInvalid(t, buildArchive(t, files, nil))
+	for _, name := range names {
+		if !slices.Contains(invalid, name+": unexpected entry") {
+			t.Errorf("%s wasn't rejected: %v", name, invalid)
+		}
+	}
+
+	if exists(outside) || exists(filepath.Join(filepath.Dir(outside), "..", "evil.jpg")) {
+		t.Error("a file was written outside the library")
+	}
+}
@@ -0,0 +1,192 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Games without a banner on Steam get one made from a few of their own
+// screenshots with the game's name across the bottom.  Favorites are used
+// first, then the newest screenshots.  The banner is made again when the name
+// or the chosen screenshots change.
+
+const (
+	BannerWidth  = 460
+	BannerHeight = 215
+
+	bannerCollageImages = 3
+)
+
+var bannerFont *opentype.Font
+
+func init() {
+	var err error
+	bannerFont, err = opentype.Parse(gobold.TTF)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// generatedBanner returns the path to a generated banner for appid.
+func (s *Server) generatedBanner(appid string) (string, bool) {
+	name, _ := s.getGameName(appid)
+	sources := s.bannerSources(appid)
+
+	// Key on everything the banner is made from.
+	hash := sha256.New()
+	hash.Write([]byte(name))
+	for _, md := range sources {
+		hash.Write([]byte{0})
+		hash.Write([]byte(md.Filename))
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	filename, err := s.Banners.Generated(appid, key, func() ([]byte, error) {
+		images := []image.Image{}
+		for _, md := range sources {
+			img, err := s.decodeForBanner(md)
+			if err != nil {
+				fmt.Printf("unable to use %s/%s for a banner: %s\n", appid, md.Filename, err)
+				continue
+			}
+			images = append(images, img)
+		}
+
+		buf := &bytes.Buffer{}
+		err := jpeg.Encode(buf, renderBanner(name, images), &jpeg.Options{Quality: 85})
+		return buf.Bytes(), err
+	})
+
+	if err != nil {
+		fmt.Printf("unable to generate a banner for %s: %s\n", appid, err)
+		return "", false
+	}
+	return filename, true
+}
+
+// bannerSources picks the screenshots to use in a generated banner.
+func (s *Server) bannerSources(appid string) []Metadata {
+	images := slices.DeleteFunc(s.gameMetadata(appid), func(md Metadata) bool {
+		format := FormatFor(md.Filename)
+		return md.Video || format == nil || format.Decode == nil || (md.HDR && md.Original == "")
+	})
+
+	slices.SortFunc(images, func(a, b Metadata) int {
+		if a.Favorite != b.Favorite {
+			if a.Favorite {
+				return -1
+			}
+			return 1
+		}
+		return -compareMetadata(a, b)
+	})
+
+	if len(images) > bannerCollageImages {
+		images = images[:bannerCollageImages]
+	}
+	return images
+}
+
+// decodeForBanner decodes a screenshot, or its SDR preview for HDR images.
+func (s *Server) decodeForBanner(md Metadata) (image.Image, error) {
+	fullpath := filepath.Join(s.settings.ImageDirectory, md.AppId, md.Filename)
+	decode := FormatFor(md.Filename).Decode
+	if md.HDR {
+		fullpath = filepath.Join(s.settings.ImageDirectory, md.AppId, "previews", md.Filename)
+		decode = jpeg.Decode
+	}
+
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return decode(file)
+}
+
+// renderBanner draws the images side by side, each cropped to fill its share
+// of the banner, with name on a dark band along the bottom.
+func renderBanner(name string, images []image.Image) *image.RGBA {
+	banner := image.NewRGBA(image.Rect(0, 0, BannerWidth, BannerHeight))
+	draw.Draw(banner, banner.Bounds(), &image.Uniform{color.RGBA{0x2a, 0x47, 0x5e, 0xff}}, image.Point{}, draw.Src)
+
+	for i, img := range images {
+		slot := image.Rect(BannerWidth*i/len(images), 0, BannerWidth*(i+1)/len(images), BannerHeight)
+		draw.ApproxBiLinear.Scale(banner, slot, img, cropToFit(img.Bounds(), slot.Dx(), slot.Dy()), draw.Src, nil)
+	}
+
+	band := image.Rect(0, BannerHeight-48, BannerWidth, BannerHeight)
+	draw.Draw(banner, band, &image.Uniform{color.RGBA{0, 0, 0, 0xb0}}, image.Point{}, draw.Over)
+	drawBannerName(banner, name, band)
+	return banner
+}
+
+// cropToFit returns the largest centered part of src with the aspect ratio of
+// width x height.
+func cropToFit(src image.Rectangle, width, height int) image.Rectangle {
+	w, h := src.Dx(), src.Dy()
+	if w*height > h*width {
+		w = h * width / height
+	} else {
+		h = w * height / width
+	}
+
+	corner := src.Min.Add(image.Pt((src.Dx()-w)/2, (src.Dy()-h)/2))
+	return image.Rectangle{Min: corner, Max: corner.Add(image.Pt(w, h))}
+}
+
+// drawBannerName draws name centered in band, shrinking the text to fit and
+// cutting it short if it still doesn't.
+func drawBannerName(img *image.RGBA, name string, band image.Rectangle) {
+	maxWidth := fixed.I(band.Dx() - 20)
+
+	var face font.Face
+	for size := 28.0; size >= 14; size -= 2 {
+		var err error
+		face, err = opentype.NewFace(bannerFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			fmt.Println("unable to load banner font:", err)
+			return
+		}
+		if font.MeasureString(face, name) <= maxWidth || size <= 14 {
+			break
+		}
+		face.Close()
+	}
+	defer face.Close()
+
+	runes := []rune(name)
+	for len(runes) > 0 && font.MeasureString(face, name) > maxWidth {
+		runes = runes[:len(runes)-1]
+		name = strings.TrimSpace(string(runes)) + "…"
+	}
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.White,
+		Face: face,
+	}
+	metrics := face.Metrics()
+	textHeight := metrics.Ascent + metrics.Descent
+	d.Dot = fixed.Point26_6{
+		X: fixed.I(band.Min.X) + (fixed.I(band.Dx())-d.MeasureString(name))/2,
+		Y: fixed.I(band.Min.Y) + (fixed.I(band.Dy())-textHeight)/2 + metrics.Ascent,
+	}
+	d.DrawString(name)
+}
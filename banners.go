@@ -0,0 +1,386 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Banners are downloaded from Steam's CDN into BannerDirectory and kept track
+// of in BannerInfoFile.  Banners are downloaded in the background the first
+// time they're asked for, and refreshed once they're older than BannerMaxAge
+// days.  Games without a banner, or whose banner is still being downloaded,
+// show the one generated from their screenshots (see bannergen.go); Steam is asked
+// again after a day, or after a few minutes if the request failed outright.
+// Uploaded banners replace the downloaded one until they're removed.
+
+const (
+	DefaultBannerUrl    = "https://cdn.akamai.steamstatic.com/steam/apps"
+	DefaultBannerMaxAge = 30 // days
+
+	BannerInfoFile = "banners.json" // in BannerDirectory
+
+	bannerMissRetry  = 24 * time.Hour   // after a 4xx
+	bannerErrorRetry = 10 * time.Minute // after a 5xx or network error
+
+	MaxBannerSize = 5 << 20
+)
+
+type BannerInfo struct {
+	Source    string    // URL of the last download attempt
+	FetchedAt time.Time // time of the last download attempt
+	Status    int       // HTTP status of the last attempt, 0 if there wasn't a response
+	Error     string    `json:",omitempty"`
+
+	File   string `json:",omitempty"` // downloaded banner, if there is one
+	Custom string `json:",omitempty"` // uploaded banner, if there is one
+
+	// Banner made from the game's screenshots, and what it was made from.
+	Generated    string `json:",omitempty"`
+	GeneratedKey string `json:",omitempty"`
+}
+
+// due reports whether it's time to download the banner again.
+func (info *BannerInfo) due(maxAge time.Duration) bool {
+	if info == nil {
+		return true
+	}
+
+	wait := maxAge
+	switch {
+	case info.Status == http.StatusOK:
+	case info.Status >= 400 && info.Status < 500:
+		wait = bannerMissRetry
+	default:
+		wait = bannerErrorRetry
+	}
+	return time.Since(info.FetchedAt) >= wait
+}
+
+type BannerStore struct {
+	Dir     string
+	BaseUrl string
+	MaxAge  time.Duration
+	Client  *http.Client
+
+	info    map[string]*BannerInfo
+	locks   map[string]*sync.Mutex // one per appid, held while downloading
+	m       sync.Mutex
+	pending sync.WaitGroup // background downloads
+}
+
+func LoadBanners(dir, baseUrl string, maxAge time.Duration) (*BannerStore, error) {
+	bs := &BannerStore{
+		Dir:     dir,
+		BaseUrl: baseUrl,
+		MaxAge:  maxAge,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+		info:    make(map[string]*BannerInfo),
+		locks:   make(map[string]*sync.Mutex),
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, BannerInfoFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return bs, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(raw, &bs.info); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", BannerInfoFile, err)
+	}
+	return bs, nil
+}
+
+// save writes the banner info to disk.  It expects bs.m to be held.
+func (bs *BannerStore) save() {
+	raw, err := json.MarshalIndent(bs.info, "", "\t")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(bs.Dir, BannerInfoFile), raw, 0644)
+	}
+
+	if err != nil {
+		fmt.Println("unable to save banner info:", err)
+	}
+}
+
+// Info returns a copy of the banner info for appid.
+func (bs *BannerStore) Info(appid string) (BannerInfo, bool) {
+	bs.m.Lock()
+	defer bs.m.Unlock()
+
+	info, ok := bs.info[appid]
+	if !ok {
+		return BannerInfo{}, false
+	}
+	return *info, true
+}
+
+// setInfo replaces the banner info for appid.  The generated banner is kept
+// as it's stored, since Generated doesn't hold the appid's lock.
+func (bs *BannerStore) setInfo(appid string, info BannerInfo) {
+	bs.m.Lock()
+	defer bs.m.Unlock()
+
+	if current, ok := bs.info[appid]; ok {
+		info.Generated, info.GeneratedKey = current.Generated, current.GeneratedKey
+	}
+	bs.info[appid] = &info
+	bs.save()
+}
+
+func (bs *BannerStore) setGenerated(appid, filename, key string) {
+	bs.m.Lock()
+	defer bs.m.Unlock()
+
+	info, ok := bs.info[appid]
+	if !ok {
+		info = &BannerInfo{}
+		bs.info[appid] = info
+	}
+	info.Generated, info.GeneratedKey = filename, key
+	bs.save()
+}
+
+func (bs *BannerStore) lock(appid string) *sync.Mutex {
+	bs.m.Lock()
+	defer bs.m.Unlock()
+
+	l, ok := bs.locks[appid]
+	if !ok {
+		l = &sync.Mutex{}
+		bs.locks[appid] = l
+	}
+	return l
+}
+
+// Get returns the path to appid's banner.  ok is false if the game doesn't
+// have one on Steam, or it hasn't been downloaded yet.  Downloads happen in the
+// background so pages never wait on Steam.
+func (bs *BannerStore) Get(appid string) (string, bool) {
+	l := bs.lock(appid)
+	l.Lock()
+	defer l.Unlock()
+
+	info, known := bs.Info(appid)
+	if info.Custom != "" {
+		return filepath.Join(bs.Dir, info.Custom), true
+	}
+
+	// Banners from before the info file was added are refreshed too.
+	file := info.File
+	if !known && exists(filepath.Join(bs.Dir, appid+".jpg")) {
+		file = appid + ".jpg"
+	}
+
+	var infoPtr *BannerInfo
+	if known {
+		infoPtr = &info
+	}
+
+	if infoPtr.due(bs.MaxAge) {
+		// Starts once the deferred unlock has run.
+		bs.pending.Add(1)
+		go func() {
+			defer bs.pending.Done()
+			bs.refresh(appid)
+		}()
+	}
+
+	if file == "" {
+		return "", false
+	}
+	return filepath.Join(bs.Dir, file), true
+}
+
+// refresh downloads a banner in the background.  The old one, or the
+// generated one, is served until it's done.  It waits for whoever holds the appid's lock, and does nothing if
+// they already refreshed the banner.
+func (bs *BannerStore) refresh(appid string) {
+	l := bs.lock(appid)
+	l.Lock()
+	defer l.Unlock()
+
+	info, known := bs.Info(appid)
+	if known && !info.due(bs.MaxAge) {
+		return
+	}
+
+	if info.File == "" && exists(filepath.Join(bs.Dir, appid+".jpg")) {
+		info.File = appid + ".jpg"
+	}
+	bs.download(appid, info)
+}
+
+// download fetches the banner from Steam and records the result.  It expects
+// the appid's lock to be held.
+func (bs *BannerStore) download(appid string, info BannerInfo) BannerInfo {
+	// Non-Steam games don't have banners on the CDN.
+	if _, err := strconv.ParseUint(appid, 10, 32); err != nil {
+		info.FetchedAt = time.Now()
+		info.Status = http.StatusNotFound
+		bs.setInfo(appid, info)
+		return info
+	}
+
+	info.Source = bs.BaseUrl + "/" + appid + "/header.jpg"
+	info.FetchedAt = time.Now()
+	info.Status = 0
+	info.Error = ""
+
+	resp, err := bs.Client.Get(info.Source)
+	if err != nil {
+		info.Error = err.Error()
+		bs.setInfo(appid, info)
+		return info
+	}
+	defer resp.Body.Close()
+
+	info.Status = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		filename := appid + ".jpg"
+		var raw []byte
+		raw, err = io.ReadAll(io.LimitReader(resp.Body, MaxBannerSize+1))
+		if err == nil && len(raw) > MaxBannerSize {
+			err = fmt.Errorf("banner is larger than %d bytes", MaxBannerSize)
+		}
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(bs.Dir, filename), bytes.NewReader(raw))
+		}
+		if err != nil {
+			info.Status = 0
+			info.Error = err.Error()
+		} else {
+			info.File = filename
+		}
+
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		// The game doesn't have a banner (any more).
+		if info.File != "" {
+			if err := os.Remove(filepath.Join(bs.Dir, info.File)); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("unable to remove banner %s: %s\n", info.File, err)
+			}
+			info.File = ""
+		}
+
+	default:
+		info.Error = resp.Status
+	}
+
+	if info.Error != "" {
+		fmt.Printf("unable to download banner for %s: %s\n", appid, info.Error)
+	}
+
+	bs.setInfo(appid, info)
+	return info
+}
+
+// SetCustom saves an uploaded banner for appid.  ext is the file extension
+// including the dot.
+func (bs *BannerStore) SetCustom(appid, ext string, r io.Reader) error {
+	l := bs.lock(appid)
+	l.Lock()
+	defer l.Unlock()
+
+	info, _ := bs.Info(appid)
+	filename := appid + ".custom" + ext
+	if err := writeFileAtomic(filepath.Join(bs.Dir, filename), r); err != nil {
+		return err
+	}
+
+	if info.Custom != "" && info.Custom != filename {
+		os.Remove(filepath.Join(bs.Dir, info.Custom))
+	}
+
+	info.Custom = filename
+	bs.setInfo(appid, info)
+	return nil
+}
+
+// RemoveCustom deletes an uploaded banner.  ok is false if there wasn't one.
+func (bs *BannerStore) RemoveCustom(appid string) (ok bool, err error) {
+	l := bs.lock(appid)
+	l.Lock()
+	defer l.Unlock()
+
+	info, _ := bs.Info(appid)
+	if info.Custom == "" {
+		return false, nil
+	}
+
+	err = os.Remove(filepath.Join(bs.Dir, info.Custom))
+	if err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+
+	info.Custom = ""
+	bs.setInfo(appid, info)
+	return true, nil
+}
+
+// Generated returns the path to a banner made by render.  key identifies what
+// the banner is made from; render is only called if the banner doesn't exist
+// yet or key has changed.
+func (bs *BannerStore) Generated(appid, key string, render func() ([]byte, error)) (string, error) {
+	// Not the appid's lock, which is held while downloading.
+	l := bs.lock("generated/" + appid)
+	l.Lock()
+	defer l.Unlock()
+
+	info, _ := bs.Info(appid)
+	if info.Generated != "" && info.GeneratedKey == key && exists(filepath.Join(bs.Dir, info.Generated)) {
+		return filepath.Join(bs.Dir, info.Generated), nil
+	}
+
+	raw, err := render()
+	if err != nil {
+		return "", err
+	}
+
+	filename := appid + ".generated.jpg"
+	if err = writeFileAtomic(filepath.Join(bs.Dir, filename), bytes.NewReader(raw)); err != nil {
+		return "", err
+	}
+
+	bs.setGenerated(appid, filename, key)
+	return filepath.Join(bs.Dir, filename), nil
+}
+
+// writeFileAtomic writes r to a temporary file next to filename and renames
+// it into place.
+func writeFileAtomic(filename string, r io.Reader) error {
+	out, err := os.CreateTemp(filepath.Dir(filename), ".banner-*")
+	if err != nil {
+		return err
+	}
+	tmpname := out.Name()
+
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpname, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpname, filename)
+	}
+
+	if err != nil {
+		os.Remove(tmpname)
+	}
+	return err
+}
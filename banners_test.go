@@ -0,0 +1,273 @@
+package steamscreenshots
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCdn serves a banner for appid 440, one that's too large for 730, one
+// for 570 once slow is closed, 404 for everything else, or 503 for everything
+// while down is set.
+type fakeCdn struct {
+	server   *httptest.Server
+	requests atomic.Int32
+	down     atomic.Bool
+	slow     chan struct{}
+}
+
+func newFakeCdn(t *testing.T) *fakeCdn {
+	f := &fakeCdn{slow: make(chan struct{})}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.requests.Add(1)
+		switch {
+		case f.down.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/apps/440/header.jpg":
+			w.Write([]byte("banner 440"))
+		case r.URL.Path == "/apps/730/header.jpg":
+			w.Write(make([]byte, MaxBannerSize+1))
+		case r.URL.Path == "/apps/570/header.jpg":
+			<-f.slow
+			w.Write([]byte("banner 570"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(func() {
+		select {
+		case <-f.slow:
+		default:
+			close(f.slow)
+		}
+		f.server.Close()
+	})
+	return f
+}
+
+func newTestBanners(t *testing.T, cdn *fakeCdn) *BannerStore {
+	bs, err := LoadBanners(t.TempDir(), cdn.server.URL+"/apps", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+// fetch is Get once the download it starts has finished.
+func fetch(bs *BannerStore, appid string) (string, bool) {
+	bs.Get(appid)
+	bs.pending.Wait()
+	return bs.Get(appid)
+}
+
+func TestBannerDownload(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+
+	// Pages don't wait for the first download.
+	if _, ok := bs.Get("440"); ok {
+		t.Error("got a banner before it was downloaded")
+	}
+	bs.pending.Wait()
+
+	filename, ok := bs.Get("440")
+	if !ok {
+		t.Fatal("banner wasn't downloaded")
+	}
+	if raw, _ := os.ReadFile(filename); string(raw) != "banner 440" {
+		t.Errorf("unexpected banner contents %q", raw)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("unexpected banner file mode: %v, %v", info.Mode(), err)
+	}
+
+	// Cached until it expires.
+	bs.Get("440")
+	if n := cdn.requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+
+	reloaded, err := LoadBanners(bs.Dir, bs.BaseUrl, bs.MaxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := reloaded.Info("440"); info.Status != http.StatusOK || !strings.HasSuffix(info.Source, "/apps/440/header.jpg") {
+		t.Errorf("unexpected banner info after reloading: %+v", info)
+	}
+}
+
+func TestBannerRetry(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+
+	// An outage isn't remembered for long.
+	cdn.down.Store(true)
+	if _, ok := fetch(bs, "440"); ok {
+		t.Fatal("got a banner while the CDN is down")
+	}
+	cdn.down.Store(false)
+
+	bs.Get("440")
+	if n := cdn.requests.Load(); n != 1 {
+		t.Errorf("retried too soon; %d requests", n)
+	}
+
+	bs.m.Lock()
+	bs.info["440"].FetchedAt = time.Now().Add(-bannerErrorRetry)
+	bs.m.Unlock()
+	if _, ok := fetch(bs, "440"); !ok {
+		t.Error("banner wasn't downloaded after the retry interval")
+	}
+
+	// Games without a banner are asked about again after a day.
+	if _, ok := fetch(bs, "12345"); ok {
+		t.Fatal("got a banner for a game without one")
+	}
+	if info, _ := bs.Info("12345"); info.due(bs.MaxAge) || info.FetchedAt.Add(bannerMissRetry).Before(time.Now()) {
+		t.Errorf("missing banner is due too soon: %+v", info)
+	}
+
+	// Non-Steam games never reach the CDN.
+	before := cdn.requests.Load()
+	fetch(bs, "12345678901234567890")
+	if cdn.requests.Load() != before {
+		t.Error("asked the CDN about a non-Steam game")
+	}
+}
+
+func TestCustomBanner(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+
+	if err := bs.SetCustom("440", ".png", strings.NewReader("custom")); err != nil {
+		t.Fatal(err)
+	}
+
+	filename, ok := bs.Get("440")
+	if !ok || filepath.Base(filename) != "440.custom.png" {
+		t.Fatalf("custom banner not used: %q, %v", filename, ok)
+	}
+	if n := cdn.requests.Load(); n != 0 {
+		t.Errorf("downloaded a banner with a custom one set")
+	}
+
+	if ok, err := bs.RemoveCustom("440"); !ok || err != nil {
+		t.Fatalf("RemoveCustom returned %v, %v", ok, err)
+	}
+	if filename, _ := fetch(bs, "440"); filepath.Base(filename) != "440.jpg" {
+		t.Errorf("expected the downloaded banner after removing the custom one, got %q", filename)
+	}
+}
+
+func TestGeneratedBanner(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+
+	renders := 0
+	render := func() ([]byte, error) {
+		renders++
+		return []byte("generated"), nil
+	}
+
+	filename, err := bs.Generated("12345", "a", render)
+	if err != nil || filepath.Base(filename) != "12345.generated.jpg" {
+		t.Fatalf("Generated returned %q, %v", filename, err)
+	}
+
+	// Only made again when what it's made from changes.
+	bs.Generated("12345", "a", render)
+	if renders != 1 {
+		t.Errorf("expected 1 render with the same key, got %d", renders)
+	}
+	bs.Generated("12345", "b", render)
+	if renders != 2 {
+		t.Errorf("expected a new render after the key changed, got %d", renders)
+	}
+}
+
+func TestRenderBanner(t *testing.T) {
+	wide := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	tall := image.NewRGBA(image.Rect(0, 0, 100, 400))
+
+	banner := renderBanner("A game with a very long name that won't fit on the banner at all", []image.Image{wide, tall})
+	if b := banner.Bounds(); b.Dx() != BannerWidth || b.Dy() != BannerHeight {
+		t.Errorf("unexpected banner size %v", b)
+	}
+
+	if crop := cropToFit(wide.Bounds(), 230, 215); crop.Dy() != 1080 || crop.Min.X != (1920-crop.Dx())/2 {
+		t.Errorf("unexpected crop of a wide image: %v", crop)
+	}
+	if crop := cropToFit(tall.Bounds(), 230, 215); crop.Dx() != 100 || crop.Min.Y != (400-crop.Dy())/2 {
+		t.Errorf("unexpected crop of a tall image: %v", crop)
+	}
+}
+
+func TestBannerRefresh(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+	fetch(bs, "440")
+
+	bs.m.Lock()
+	bs.info["440"].FetchedAt = time.Now().Add(-2 * bs.MaxAge)
+	bs.m.Unlock()
+
+	// The old banner is served while a new one is downloaded.
+	if _, ok := bs.Get("440"); !ok {
+		t.Fatal("stale banner wasn't served")
+	}
+
+	bs.pending.Wait()
+	if info, _ := bs.Info("440"); info.due(bs.MaxAge) {
+		t.Errorf("banner wasn't refreshed: %+v", info)
+	}
+}
+
+func TestBannerTooLarge(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+
+	if _, ok := fetch(bs, "730"); ok {
+		t.Fatal("oversized banner was used")
+	}
+	if info, _ := bs.Info("730"); info.Error == "" || info.File != "" {
+		t.Errorf("oversized banner wasn't recorded as an error: %+v", info)
+	}
+}
+
+func TestGeneratedBannerWhileDownloading(t *testing.T) {
+	cdn := newFakeCdn(t)
+	bs := newTestBanners(t, cdn)
+
+	if _, ok := bs.Get("570"); ok {
+		t.Fatal("got a banner before it was downloaded")
+	}
+	for cdn.requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The generated banner is served while Steam is slow.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bs.Generated("570", "a", func() ([]byte, error) { return []byte("generated"), nil })
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("generating a banner waited for the download")
+	}
+
+	close(cdn.slow)
+	bs.pending.Wait()
+
+	info, _ := bs.Info("570")
+	if info.File == "" || info.Generated == "" {
+		t.Errorf("download or generated banner lost: %+v", info)
+	}
+}
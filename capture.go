@@ -0,0 +1,159 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Steam names screenshots with the local time they were taken followed by a
+// counter, eg 20240312183045_1.jpg
+var re_steamfilename = regexp.MustCompile(`^(\d{14})_\d+`)
+
+// captureTimeFromFilename parses the capture time out of a Steam screenshot
+// filename.  Steam uses the local time of the machine that took it.
+func captureTimeFromFilename(filename string) (time.Time, bool) {
+	match := re_steamfilename.FindStringSubmatch(filepath.Base(filename))
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation("20060102150405", match[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// EXIF tags used for the capture time.
+const (
+	exifTagDateTime         uint16 = 0x0132
+	exifTagExifIFD          uint16 = 0x8769
+	exifTagDateTimeOriginal uint16 = 0x9003
+)
+
+// jpegCaptureTime reads DateTimeOriginal (or DateTime) from the EXIF data in
+// a JPEG file.
+func jpegCaptureTime(r io.Reader) (time.Time, error) {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker[:2]); err != nil {
+		return time.Time{}, err
+	}
+
+	if marker[0] != 0xFF || marker[1] != 0xD8 {
+		return time.Time{}, fmt.Errorf("not a JPEG file")
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return time.Time{}, err
+		}
+
+		if marker[0] != 0xFF {
+			return time.Time{}, fmt.Errorf("invalid JPEG marker")
+		}
+
+		// Start of scan; the metadata segments are all before this.
+		if marker[1] == 0xDA {
+			return time.Time{}, nil
+		}
+
+		length := int64(binary.BigEndian.Uint16(marker[2:4])) - 2
+		if length < 0 {
+			return time.Time{}, fmt.Errorf("invalid JPEG segment length")
+		}
+
+		if marker[1] != 0xE1 {
+			if err := skipBytes(r, length); err != nil {
+				return time.Time{}, err
+			}
+			continue
+		}
+
+		// The 16 bit length keeps segments under 64KB.
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return time.Time{}, err
+		}
+
+		if bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			return exifCaptureTime(data[6:])
+		}
+	}
+}
+
+// pngCaptureTime uses the eXIf chunk if there is one, otherwise the tIME
+// chunk.  tIME is the last modification time in UTC, which for a screenshot
+// is when it was taken.
+func pngCaptureTime(r io.Reader) (time.Time, error) {
+	data, err := findPngChunks(r, false, "eXIf", "tIME")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if exif, ok := data["eXIf"]; ok {
+		t, err := exifCaptureTime(exif)
+		if err == nil && !t.IsZero() {
+			return t, nil
+		}
+	}
+
+	tm, ok := data["tIME"]
+	if !ok || len(tm) < 7 {
+		return time.Time{}, nil
+	}
+
+	return time.Date(
+		int(binary.BigEndian.Uint16(tm[0:2])),
+		time.Month(tm[2]),
+		int(tm[3]),
+		int(tm[4]),
+		int(tm[5]),
+		int(tm[6]),
+		0, time.UTC,
+	), nil
+}
+
+// exifCaptureTime parses a TIFF structured EXIF block.  EXIF times have no
+// zone and are assumed to be local.
+func exifCaptureTime(data []byte) (time.Time, error) {
+	if len(data) < 8 {
+		return time.Time{}, fmt.Errorf("EXIF data too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, fmt.Errorf("invalid EXIF byte order")
+	}
+
+	ifd0, err := readIFD(data, order, order.Uint32(data[4:8]))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	value := ""
+	if ptr, ok := ifd0[exifTagExifIFD].Uint(order); ok {
+		exif, err := readIFD(data, order, ptr)
+		if err == nil {
+			value = exif[exifTagDateTimeOriginal].String()
+		}
+	}
+
+	if value == "" {
+		value = ifd0[exifTagDateTime].String()
+	}
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+}
@@ -11,21 +11,97 @@ import (
 
 type Arguments struct {
 	SettingsFile string `arg:"-c,--config" default:"settings.json"`
+
+	Export *ExportCmd `arg:"subcommand:export" help:"write the whole library to a tar archive"`
+	Import *ImportCmd `arg:"subcommand:import" help:"load a library archive made with export"`
+}
+
+type ExportCmd struct {
+	Output string `arg:"positional,required" help:"archive to write"`
+}
+
+type ImportCmd struct {
+	Input     string `arg:"positional,required" help:"archive to read, or - for stdin"`
+	DryRun    bool   `arg:"-n,--dry-run" help:"check the archive and report what would change without changing anything"`
+	Overwrite bool   `arg:"--overwrite" help:"replace existing files and entries that differ from the archive"`
 }
 
 func main() {
 	args := &Arguments{}
 	arg.MustParse(args)
 
-	server, err := ss.NewServer(args.SettingsFile)
+	// Exports and imports only need the library, not a running server.
+	var server *ss.Server
+	var err error
+	if args.Export != nil || args.Import != nil {
+		server, err = ss.OpenLibrary(args.SettingsFile)
+	} else {
+		server, err = ss.NewServer(args.SettingsFile)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	err = server.Run()
+	switch {
+	case args.Export != nil:
+		err = export(server, args.Export)
+	case args.Import != nil:
+		err = importArchive(server, args.Import)
+	default:
+		err = server.Run()
+	}
+
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
+
+func export(server *ss.Server, cmd *ExportCmd) error {
+	file, err := os.Create(cmd.Output)
+	if err != nil {
+		return err
+	}
+
+	err = server.Export(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(cmd.Output)
+		return err
+	}
+	return nil
+}
+
+func importArchive(server *ss.Server, cmd *ImportCmd) error {
+	input := os.Stdin
+	if cmd.Input != "-" {
+		file, err := os.Open(cmd.Input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	report, err := server.Import(input, ss.ImportOptions{
+		DryRun:    cmd.DryRun,
+		Overwrite: cmd.Overwrite,
+	})
+	if err != nil {
+		return err
+	}
+
+	report.Print(os.Stderr)
+	if !report.OK() {
+		return fmt.Errorf("archive failed its integrity check; nothing was imported")
+	}
+
+	if cmd.DryRun {
+		fmt.Fprintln(os.Stderr, "Dry run; nothing was changed.")
+	}
+	return nil
+}
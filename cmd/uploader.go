@@ -12,13 +12,18 @@ package main
 */
 
 import (
-	//"bytes"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -92,6 +97,11 @@ func run() error {
 		return err
 	}
 
+	if err = pushShortcutNames(local); err != nil {
+		// Names are cosmetic; keep uploading.
+		fmt.Println("unable to send non-Steam game names:", err)
+	}
+
 	fmt.Println("remote count:", len(remote))
 	fmt.Println("local count:", len(local))
 
@@ -112,12 +122,18 @@ func run() error {
 		}
 	}
 
+	steam, err := readScreenshotsVdf()
+	if err != nil {
+		// Only used for captions and such; keep uploading.
+		fmt.Println("unable to read screenshots.vdf:", err)
+	}
+
 	fmt.Println("new files:")
 	for appid, files := range added {
 		fmt.Println(" ", appid)
 		for _, f := range files {
 			fmt.Println("   ", f)
-			err = uploadFile(appid, f)
+			err = uploadFile(appid, f, steam[appid+"/"+f])
 			if err != nil {
 				return err
 			}
@@ -127,28 +143,145 @@ func run() error {
 	return nil
 }
 
-func uploadFile(appid, filename string) error {
+// readScreenshotsVdf reads Steam's captions and other details for each
+// screenshot.
+func readScreenshotsVdf() (map[string]ss.SteamScreenshot, error) {
+	filename := config.ScreenshotsFile
+	if filename == "" {
+		filename = filepath.Join(config.RemoteDirectory, "..", "screenshots.vdf")
+	}
+
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ss.ParseScreenshots(file)
+}
+
+func uploadFile(appid, filename string, steam ss.SteamScreenshot) error {
 	file, err := os.Open(filepath.Join(config.RemoteDirectory, appid, "screenshots", filename))
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Hash the file first so the server can verify what it received.
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
 	reqUrl := fmt.Sprintf("%s/api/upload/%s/%s", config.Server, appid, filename)
 	fmt.Println("request url: ", reqUrl)
 
 	req, err := http.NewRequest("PUT", reqUrl, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Add("api-key", config.Key)
+	req.Header.Add(ss.HeaderFileMtime, info.ModTime().Format(time.RFC3339Nano))
+	req.Header.Add(ss.HeaderFileSize, strconv.FormatInt(info.Size(), 10))
+	req.Header.Add(ss.HeaderFileSha256, hex.EncodeToString(hash.Sum(nil)))
+
+	if steam.Caption != "" {
+		req.Header.Add(ss.HeaderSteamCaption, url.PathEscape(steam.Caption))
+	}
+	if steam.Location != "" {
+		req.Header.Add(ss.HeaderSteamLocation, url.PathEscape(steam.Location))
+	}
+	if !steam.Created.IsZero() {
+		req.Header.Add(ss.HeaderSteamCreated, steam.Created.Format(time.RFC3339))
+	}
+	if steam.PublishedFileId != "" {
+		req.Header.Add(ss.HeaderSteamPublishedFileId, steam.PublishedFileId)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// pushShortcutNames sends the names of non-Steam games that have screenshots
+// to the server.  Their appids are generated by Steam, so it has no other way
+// of knowing them.
+func pushShortcutNames(local map[string][]string) error {
+	filename := config.ShortcutsFile
+	if filename == "" {
+		// RemoteDirectory is userdata/<id>/760/remote
+		filename = filepath.Join(config.RemoteDirectory, "..", "..", "config", "shortcuts.vdf")
+	}
+
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	shortcuts, err := ss.ParseShortcuts(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	names := ss.GameIDs{}
+	for _, sc := range shortcuts {
+		for _, id := range []string{sc.GameId(), strconv.FormatUint(uint64(sc.AppId), 10)} {
+			if _, ok := local[id]; ok {
+				names[id] = sc.Name
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", config.Server+"/api/v1/games/names", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
 	req.Header.Add("api-key", config.Key)
+	req.Header.Add("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("HTTP error: %s", resp.Status)
 	}
 
+	fmt.Printf("sent %d non-Steam game names\n", len(names))
 	return nil
 }
 
@@ -157,6 +290,8 @@ type Configuration struct {
 	Key             string // Upload key.  This needs to be kept private.
 	RemoteDirectory string // steam's "remote" directory
 	Interval        int    // Interval in seconds between upload checks (0 = run once)
+	ShortcutsFile   string // shortcuts.vdf for non-Steam game names.  Found from RemoteDirectory if empty.
+	ScreenshotsFile string // Steam's screenshots.vdf for captions.  Found from RemoteDirectory if empty.
 }
 
 func ReadConfig(filename string) (*Configuration, error) {
@@ -278,11 +413,8 @@ func scanForImages(root string) (map[string][]string, error) {
 				continue
 			}
 
-			switch strings.ToLower(filepath.Ext(file.Name())) {
-			case ".jpg", ".jpeg", ".png":
+			if ss.IsSupportedFormat(file.Name()) {
 				images[dname] = append(images[dname], file.Name())
-			default:
-				// nope
 			}
 		}
 	}
@@ -0,0 +1,199 @@
+package steamscreenshots
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ZIP downloads are streamed straight to the client.  Files are stored
+// without compression since images and clips are already compressed, which
+// also keeps this cheap enough to do on the fly.
+
+// MaxDownloadSelection limits the number of images in a selection download.
+const MaxDownloadSelection = 5000
+
+type zipEntry struct {
+	AppId    string
+	Filename string
+	Name     string // path inside the archive
+}
+
+// GET /game/{appid}/download.zip
+func (s *Server) handler_download_game(w http.ResponseWriter, r *http.Request) {
+	appid := s.Aliases.Primary(r.PathValue("appid"))
+
+	if !s.hasGame(appid) {
+		http.NotFound(w, r)
+		return
+	}
+	images := s.gameMetadata(appid)
+
+	// Images from aliases go in a folder per appid, since their filenames can
+	// be the same as the primary's.
+	entries := []zipEntry{}
+	for _, md := range images {
+		name := md.Filename
+		if md.AppId != appid {
+			name = md.AppId + "/" + md.Filename
+		}
+
+		entries = append(entries, zipEntry{
+			AppId:    md.AppId,
+			Filename: md.Filename,
+			Name:     name,
+		})
+	}
+
+	name, _ := s.getGameName(appid)
+	s.sendZip(w, zipFilename(name), entries)
+}
+
+// POST /download.zip
+//
+// Downloads a selection of images from any number of games.  The form has an
+// "image" value of "appid/filename" for each image.  Each game gets its own
+// folder in the archive, laid out like the game's own download.
+func (s *Server) handler_download_selection(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	selected := r.PostForm["image"]
+	if len(selected) == 0 {
+		http.Error(w, "no images selected", http.StatusBadRequest)
+		return
+	}
+
+	if len(selected) > MaxDownloadSelection {
+		http.Error(w, fmt.Sprintf("too many images selected; the limit is %d", MaxDownloadSelection), http.StatusBadRequest)
+		return
+	}
+
+	entries := []zipEntry{}
+	seen := map[string]bool{}
+	folders := map[string]string{} // folder name to appid
+	for _, val := range selected {
+		appid, filename, found := strings.Cut(val, "/")
+		if !found {
+			http.Error(w, fmt.Sprintf("invalid image: %s", val), http.StatusBadRequest)
+			return
+		}
+		if seen[val] {
+			continue
+		}
+		seen[val] = true
+
+		// Only files in the index can be downloaded.  This keeps anything
+		// else in the image directory private, and paths out of it.
+		if _, ok := s.ImageCache.GetImage(appid, filename); !ok {
+			http.Error(w, fmt.Sprintf("image not found: %s", val), http.StatusNotFound)
+			return
+		}
+
+		// Games with the same name get separate folders.  Aliases go in
+		// their primary's.
+		primary := s.Aliases.Primary(appid)
+		gameName, _ := s.getGameName(primary)
+		folder := zipFolder(gameName)
+		if id, ok := folders[folder]; ok && id != primary {
+			folder += " (" + primary + ")"
+		}
+		folders[folder] = primary
+
+		name := folder + "/" + filename
+		if appid != primary {
+			name = folder + "/" + appid + "/" + filename
+		}
+
+		entries = append(entries, zipEntry{
+			AppId:    appid,
+			Filename: filename,
+			Name:     name,
+		})
+	}
+
+	s.sendZip(w, "screenshots.zip", entries)
+}
+
+// sendZip streams the given images as a ZIP archive.  Once the headers are
+// sent errors can't be reported to the client, so they're logged and the
+// archive is cut short.
+func (s *Server) sendZip(w http.ResponseWriter, filename string, entries []zipEntry) {
+	// Large galleries take much longer to send than the server's write
+	// timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		err := s.writeZipEntry(zw, entry)
+		if err != nil {
+			fmt.Printf("error writing %s/%s to %s: %s\n", entry.AppId, entry.Filename, filename, err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		fmt.Printf("error finishing %s: %s\n", filename, err)
+	}
+}
+
+func (s *Server) writeZipEntry(zw *zip.Writer, entry zipEntry) error {
+	file, err := os.Open(filepath.Join(s.settings.ImageDirectory, entry.AppId, entry.Filename))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.Name
+	header.Method = zip.Store
+
+	out, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, file)
+	return err
+}
+
+// zipFolder makes a game name safe to use as a folder name in an archive.
+func zipFolder(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+func zipFilename(name string) string {
+	return zipFolder(name) + ".zip"
+}
@@ -0,0 +1,132 @@
+package steamscreenshots
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newDownloadServer serves the given "appid/filename" images, each holding
+// its own name.
+func newDownloadServer(t *testing.T, images ...string) *Server {
+	dir := t.TempDir()
+	s := newTestServer(t, newFakeSteam(t), newTestGameList(t), Settings{
+		ImageDirectory: dir,
+		NameResolvers:  []string{ResolverOverrides, ResolverCache},
+	})
+	s.ImageCache = NewGameImages()
+
+	for _, image := range images {
+		appid, filename, _ := strings.Cut(image, "/")
+		os.MkdirAll(filepath.Join(dir, appid), 0755)
+		if err := os.WriteFile(filepath.Join(dir, appid, filename), []byte(image), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if s.ImageCache.Games[appid] == nil {
+			s.ImageCache.Games[appid] = map[string]*ImageMeta{}
+		}
+		s.ImageCache.Games[appid][filename] = &ImageMeta{}
+	}
+	return s
+}
+
+func downloadSelection(s *Server, images ...string) *httptest.ResponseRecorder {
+	form := url.Values{"image": images}
+	req := httptest.NewRequest("POST", "/download.zip", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.handler_download_selection(w, req)
+	return w
+}
+
+// zipNames returns the sorted names in a ZIP response.
+func zipNames(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("%d %s: %s", w.Code, w.Body, err)
+	}
+
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestSelectionFolders(t *testing.T) {
+	s := newDownloadServer(t, "220/a.jpg", "221/a.jpg")
+	s.Aliases.Set("221", "220")
+	s.Overrides.Set("220", "Half-Life 2")
+
+	// Named like the game's own download, with aliases in the primary's folder.
+	names := zipNames(t, downloadSelection(s, "220/a.jpg", "221/a.jpg"))
+	if !slices.Equal(names, []string{"Half-Life 2/221/a.jpg", "Half-Life 2/a.jpg"}) {
+		t.Errorf("unexpected zip entries: %v", names)
+	}
+}
+
+func TestSelectionOnlyIndexedImages(t *testing.T) {
+	s := newDownloadServer(t, "220/a.jpg")
+
+	// Files in the image directory that aren't in the index stay private.
+	secret := filepath.Join(s.settings.ImageDirectory, "220", "secret.txt")
+	os.WriteFile(secret, []byte("not for download"), 0644)
+
+	for _, image := range []string{
+		"220/secret.txt",
+		"220/b.jpg",
+		"220/../220/a.jpg",
+		"../220/a.jpg",
+		"220/../../settings.json",
+		"a.jpg",
+	} {
+		w := downloadSelection(s, "220/a.jpg", image)
+		if w.Code != http.StatusNotFound && w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected an error, got %d", image, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "not for download") {
+			t.Errorf("%s: sent a file that isn't indexed", image)
+		}
+	}
+}
+
+func TestSelectionLimit(t *testing.T) {
+	s := newDownloadServer(t, "220/a.jpg")
+
+	images := make([]string, MaxDownloadSelection+1)
+	for i := range images {
+		images[i] = fmt.Sprintf("220/%d.jpg", i)
+	}
+	if w := downloadSelection(s, images...); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 over the limit, got %d", w.Code)
+	}
+
+	if w := downloadSelection(s); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without images, got %d", w.Code)
+	}
+}
+
+func TestSelectionDuplicateNames(t *testing.T) {
+	s := newDownloadServer(t, "70/a.jpg", "70/b.jpg", "130/a.jpg", "220/a.jpg")
+	s.Games.Set("70", "Half-Life")
+	s.Games.Set("130", "Half-Life")
+	s.Games.Set("220", "Half-Life: Source?")
+
+	names := zipNames(t, downloadSelection(s, "70/a.jpg", "130/a.jpg", "70/b.jpg", "70/a.jpg", "220/a.jpg"))
+	expect := []string{"Half-Life (130)/a.jpg", "Half-Life/a.jpg", "Half-Life/b.jpg", "Half-Life_ Source_/a.jpg"}
+	if !slices.Equal(names, expect) {
+		t.Errorf("unexpected zip entries: %v", names)
+	}
+}
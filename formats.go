@@ -0,0 +1,221 @@
+package steamscreenshots
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// ImageFormat knows how to read one type of file.
+type ImageFormat struct {
+	Name       string
+	Extensions []string // lower case, with the leading dot
+	MimeType   string
+
+	// Dimensions without decoding the whole file.  Unused for video.
+	DecodeConfig func(r io.Reader) (image.Config, error)
+
+	// Dimensions and duration of video clips.  Formats with this set are
+	// treated as video.
+	Probe func(r io.Reader) (videoInfo, error)
+
+	// Decode the image for thumbnail generation.  Leave nil for formats
+	// that can't be decoded; a placeholder thumbnail will be generated.
+	Decode func(r io.Reader) (image.Image, error)
+
+	// Scale the decoded image down to a thumbnail.  Defaults to
+	// scaleThumbnail if nil.
+	Thumbnail func(img image.Image, width int) image.Image
+
+	// Reports whether the file is HDR.  Nil for SDR-only formats.
+	HDRInfo func(r io.Reader) (hdrInfo, error)
+
+	// Capture time from the file's metadata.  A zero time is returned if
+	// there isn't one.
+	CaptureTime func(r io.Reader) (time.Time, error)
+}
+
+// ThumbnailFor returns a thumbnail for the image in r.
+func (f *ImageFormat) ThumbnailFor(r io.Reader, cfg image.Config) (image.Image, error) {
+	if f.Decode == nil {
+		return placeholderThumbnail(cfg, ThumbWidth, f.Name), nil
+	}
+
+	img, err := f.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Thumbnail != nil {
+		return f.Thumbnail(img, ThumbWidth), nil
+	}
+	return scaleThumbnail(img, ThumbWidth), nil
+}
+
+var imageFormats = []*ImageFormat{
+	&ImageFormat{
+		Name:         "JPEG",
+		Extensions:   []string{".jpg", ".jpeg"},
+		MimeType:     "image/jpeg",
+		DecodeConfig: jpeg.DecodeConfig,
+		Decode:       jpeg.Decode,
+		CaptureTime:  jpegCaptureTime,
+	},
+	&ImageFormat{
+		Name:         "PNG",
+		Extensions:   []string{".png"},
+		MimeType:     "image/png",
+		DecodeConfig: png.DecodeConfig,
+		Decode:       png.Decode,
+		HDRInfo:      pngHDRInfo,
+		CaptureTime:  pngCaptureTime,
+	},
+	&ImageFormat{
+		Name:         "WebP",
+		Extensions:   []string{".webp"},
+		MimeType:     "image/webp",
+		DecodeConfig: webp.DecodeConfig,
+		Decode:       webp.Decode,
+	},
+	&ImageFormat{
+		// gif.Decode only returns the first frame, which is what we want
+		// for the thumbnail.
+		Name:         "GIF",
+		Extensions:   []string{".gif"},
+		MimeType:     "image/gif",
+		DecodeConfig: gif.DecodeConfig,
+		Decode:       gif.Decode,
+	},
+	&ImageFormat{
+		Name:         "BMP",
+		Extensions:   []string{".bmp"},
+		MimeType:     "image/bmp",
+		DecodeConfig: bmp.DecodeConfig,
+		Decode:       bmp.Decode,
+	},
+	&ImageFormat{
+		Name:         "TIFF",
+		Extensions:   []string{".tif", ".tiff"},
+		MimeType:     "image/tiff",
+		DecodeConfig: tiff.DecodeConfig,
+		Decode:       tiff.Decode,
+	},
+	&ImageFormat{
+		// There's no pure Go HEIF decoder, so these only get a placeholder
+		// thumbnail.
+		Name:         "HEIF",
+		Extensions:   []string{".heic", ".heif"},
+		MimeType:     "image/heif",
+		DecodeConfig: heifConfig,
+	},
+	&ImageFormat{
+		// HDR formats without a decoder.  Thumbnails are made from the SDR
+		// copy Steam saves alongside them, if there is one.
+		Name:         "AVIF",
+		Extensions:   []string{".avif"},
+		MimeType:     "image/avif",
+		DecodeConfig: heifConfig,
+		HDRInfo:      avifHDRInfo,
+	},
+	&ImageFormat{
+		Name:         "JPEG XR",
+		Extensions:   []string{".jxr", ".wdp", ".hdp"},
+		MimeType:     "image/jxr",
+		DecodeConfig: jxrConfig,
+		HDRInfo:      jxrHDRInfo,
+	},
+	&ImageFormat{
+		Name:       "MP4",
+		Extensions: []string{".mp4", ".m4v"},
+		MimeType:   "video/mp4",
+		Probe:      mp4Info,
+	},
+	&ImageFormat{
+		Name:       "WebM",
+		Extensions: []string{".webm"},
+		MimeType:   "video/webm",
+		Probe:      webmInfo,
+	},
+}
+
+// FormatFor returns the format for the given filename based on its extension,
+// ignoring case.  Nil is returned for unsupported files.
+func FormatFor(filename string) *ImageFormat {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, f := range imageFormats {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// IsVideo returns true for video clip formats.
+func (f *ImageFormat) IsVideo() bool {
+	return f.Probe != nil
+}
+
+// IsSupportedFormat is used by both the server and the uploader to decide
+// which files to care about.
+func IsSupportedFormat(filename string) bool {
+	return FormatFor(filename) != nil
+}
+
+func scaleThumbnail(img image.Image, width int) image.Image {
+	ratio := float64(img.Bounds().Dy()) / float64(img.Bounds().Dx())
+	height := int(float64(width) * ratio)
+	thumbImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(thumbImg, thumbImg.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return thumbImg
+}
+
+// placeholderThumbnail draws a plain thumbnail with the given label for files
+// that can't be decoded.  The aspect ratio of the original is kept if known.
+func placeholderThumbnail(cfg image.Config, width int, label string) *image.RGBA {
+	height := width * 9 / 16
+	if cfg.Width > 0 && cfg.Height > 0 {
+		height = width * cfg.Height / cfg.Width
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0x2a, 0x47, 0x5e, 0xff}}, image.Point{}, draw.Src)
+
+	if label != "" && cfg.Width > 0 && cfg.Height > 0 {
+		label = fmt.Sprintf("%s %dx%d", label, cfg.Width, cfg.Height)
+	}
+
+	drawLabel(img, label, height/2+4)
+	return img
+}
+
+// drawLabel draws white text horizontally centered on the given baseline.
+func drawLabel(img *image.RGBA, label string, baseline int) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.White,
+		Face: basicfont.Face7x13,
+	}
+	textWidth := d.MeasureString(label)
+	d.Dot = fixed.Point26_6{
+		X: (fixed.I(img.Bounds().Dx()) - textWidth) / 2,
+		Y: fixed.I(baseline),
+	}
+	d.DrawString(label)
+}
@@ -0,0 +1,39 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Sizes in headers come from the file and mustn't be trusted.
+
+func TestBmffHugeBox(t *testing.T) {
+	box := make([]byte, 16)
+	binary.BigEndian.PutUint32(box[0:4], 1)
+	copy(box[4:8], "meta")
+	binary.BigEndian.PutUint64(box[8:16], 1<<62)
+
+	if _, err := readBmffBoxes(bytes.NewReader(box), "meta"); err == nil {
+		t.Error("expected an error for an oversized box")
+	}
+}
+
+func TestWebmHugeElement(t *testing.T) {
+	// An Info element claiming to be about 2^48 bytes.
+	data := []byte{0x15, 0x49, 0xA9, 0x66, 0x01, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	if _, err := webmInfo(bytes.NewReader(data)); err == nil {
+		t.Error("expected an error for an oversized element")
+	}
+}
+
+func TestPngHugeChunk(t *testing.T) {
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, 0xF0000000)
+	data = append(data, "iCCP"...)
+
+	if _, err := findPngChunks(bytes.NewReader(data), true, "iCCP"); err == nil {
+		t.Error("expected an error for an oversized chunk")
+	}
+}
@@ -5,21 +5,73 @@ import (
 	"sync"
 	"os"
 	"errors"
+	"slices"
 )
 
 // GameIDs maps appids to display names
 // var Games *GameList
 type GameIDs map[string]string
 
+// GameInfo is what's known about a game.  Everything but the name comes from
+// the store's appdetails or an imported dump, and may be missing.
+type GameInfo struct {
+	Name        string   `json:",omitempty"`
+	Type        string   `json:",omitempty"` // game, demo, dlc, etc.
+	Developers  []string `json:",omitempty"`
+	Genres      []string `json:",omitempty"`
+	ReleaseYear int      `json:",omitempty"`
+	HeaderUrl   string   `json:",omitempty"`
+	CapsuleUrl  string   `json:",omitempty"`
+}
+
+// HasDetails reports whether there's anything besides the name.
+func (gi GameInfo) HasDetails() bool {
+	return gi.Type != "" || len(gi.Developers) > 0 || len(gi.Genres) > 0 ||
+		gi.ReleaseYear != 0 || gi.HeaderUrl != "" || gi.CapsuleUrl != ""
+}
+
+// Games with only a name are stored as just the name, like games.cache has
+// always been.
+type gameInfoJson GameInfo
+
+func (gi GameInfo) MarshalJSON() ([]byte, error) {
+	if !gi.HasDetails() {
+		return json.Marshal(gi.Name)
+	}
+	return json.Marshal(gameInfoJson(gi))
+}
+
+func (gi *GameInfo) UnmarshalJSON(raw []byte) error {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		*gi = GameInfo{Name: name}
+		return nil
+	}
+
+	info := gameInfoJson{}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return err
+	}
+	*gi = GameInfo(info)
+	return nil
+}
+
+func (gi GameInfo) clone() GameInfo {
+	gi.Developers = slices.Clone(gi.Developers)
+	gi.Genres = slices.Clone(gi.Genres)
+	return gi
+}
+
 type GameList struct {
-	games    GameIDs
+	games    map[string]GameInfo
 	m        sync.Mutex
 	filename string
+	version  uint64 // incremented on every change
 }
 
 func LoadGameList(filename string) (*GameList, error) {
 	gl := &GameList{
-		games: make(map[string]string),
+		games: make(map[string]GameInfo),
 		m: sync.Mutex{},
 		filename: filename,
 	}
@@ -32,7 +84,7 @@ func LoadGameList(filename string) (*GameList, error) {
 	}
 	defer file.Close()
 
-	var games GameIDs
+	games := map[string]GameInfo{}
 	dec := json.NewDecoder(file)
 	err = dec.Decode(&games)
 	if err != nil {
@@ -44,8 +96,24 @@ func LoadGameList(filename string) (*GameList, error) {
 	return gl, nil
 }
 
+// Save writes the list to the file it was loaded from.
+func (g *GameList) Save() error {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.filename == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(g.games, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.filename, raw, 0644)
+}
+
 func ParseGames(raw []byte) (*GameList, error) {
-	games := make(map[string]string)
+	games := make(map[string]GameInfo)
 
 	err := json.Unmarshal(raw, &games)
 	if err != nil {
@@ -59,17 +127,29 @@ func (g *GameList) Get(id string) string {
 	g.m.Lock()
 	defer g.m.Unlock()
 
-	if val, ok := g.games[id]; ok {
-		return val
+	if val, ok := g.games[id]; ok && val.Name != "" {
+		return val.Name
 	}
 	return id
 }
 
+// Lookup returns the name for id and whether there is one.
+func (g *GameList) Lookup(id string) (string, bool) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	val := g.games[id]
+	return val.Name, val.Name != ""
+}
+
 func (g *GameList) Set(id, val string) string {
 	g.m.Lock()
 	defer g.m.Unlock()
 
-	g.games[id] = val
+	info := g.games[id]
+	info.Name = val
+	g.games[id] = info
+	g.version++
 	return val
 }
 
@@ -78,8 +158,69 @@ func (g *GameList) Update(list GameIDs) {
 	defer g.m.Unlock()
 
 	for key, val := range list {
-		g.games[key] = val
+		info := g.games[key]
+		info.Name = val
+		g.games[key] = info
 	}
+	g.version++
+}
+
+// Info returns everything known about id.
+func (g *GameList) Info(id string) (GameInfo, bool) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	info, ok := g.games[id]
+	return info.clone(), ok
+}
+
+// SetInfo replaces the details of id.  The name is only replaced if info has
+// one.
+func (g *GameList) SetInfo(id string, info GameInfo) {
+	g.UpdateInfo(map[string]GameInfo{id: info})
+}
+
+// UpdateInfo is SetInfo for many games at once.
+func (g *GameList) UpdateInfo(list map[string]GameInfo) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	for id, info := range list {
+		info = info.clone()
+		if info.Name == "" {
+			info.Name = g.games[id].Name
+		}
+		g.games[id] = info
+	}
+	g.version++
+}
+
+// Delete forgets the name for id so it's looked up again.  Details from the
+// store are kept.
+func (g *GameList) Delete(id string) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	info, ok := g.games[id]
+	if !ok {
+		return
+	}
+
+	if info.HasDetails() {
+		info.Name = ""
+		g.games[id] = info
+	} else {
+		delete(g.games, id)
+	}
+	g.version++
+}
+
+// Version changes whenever a name is added, updated or removed.
+func (g *GameList) Version() uint64 {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	return g.version
 }
 
 func (g *GameList) GetMap() GameIDs {
@@ -88,7 +229,21 @@ func (g *GameList) GetMap() GameIDs {
 
 	retList := GameIDs{}
 	for key, val := range g.games {
-		retList[key] = val
+		if val.Name != "" {
+			retList[key] = val.Name
+		}
+	}
+	return retList
+}
+
+// GetInfoMap returns a copy of everything in the list.
+func (g *GameList) GetInfoMap() map[string]GameInfo {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	retList := map[string]GameInfo{}
+	for key, val := range g.games {
+		retList[key] = val.clone()
 	}
 	return retList
 }
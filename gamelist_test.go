@@ -0,0 +1,86 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGameListFormat(t *testing.T) {
+	games := newTestGameList(t)
+
+	// Old games.cache files are just names.
+	err := os.WriteFile(games.filename, []byte(`{"70":"Half-Life","440":{"Name":"TF2","Genres":["Action"],"ReleaseYear":2007}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	games, err = LoadGameList(games.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := games.Lookup("70"); name != "Half-Life" {
+		t.Errorf("old style entry not loaded: %q", name)
+	}
+	if info, _ := games.Info("440"); info.Name != "TF2" || info.ReleaseYear != 2007 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	// Deleting a name keeps the details so they aren't looked up again.
+	games.Delete("440")
+	if _, ok := games.Lookup("440"); ok {
+		t.Error("name wasn't deleted")
+	}
+	games.Set("440", "Team Fortress 2")
+	if info, _ := games.Info("440"); info.Genres[0] != "Action" {
+		t.Errorf("details lost: %+v", info)
+	}
+
+	if err = games.Save(); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(games.filename)
+	saved := map[string]json.RawMessage{}
+	if err = json.Unmarshal(raw, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if string(saved["70"]) != `"Half-Life"` || !strings.HasPrefix(string(saved["440"]), "{") {
+		t.Errorf("unexpected games.cache: %s", raw)
+	}
+}
+
+func TestParseGameInfo(t *testing.T) {
+	for raw, expect := range map[string]string{
+		`"Half-Life"`:                     "Half-Life",
+		`{"Name":"Portal","Type":"game"}`: "Portal",
+		`{"success":true,"data":{"name":"Portal 2","release_date":{"date":"18 avr. 2011"}}}`: "Portal 2",
+		`{"success":false}`: "",
+	} {
+		info, ok, err := parseGameInfo(json.RawMessage(raw))
+		if err != nil || info.Name != expect || ok != (expect != "") {
+			t.Errorf("parseGameInfo(%s) = %+v, %v, %v", raw, info, ok, err)
+		}
+	}
+}
+
+func TestGameNamesSaveFails(t *testing.T) {
+	games := newTestGameList(t)
+	games.filename = filepath.Join(t.TempDir(), "missing", GameCacheFile)
+	s := newTestServer(t, newFakeSteam(t), games, Settings{
+		ApiKey:        "key",
+		ApiWhitelist:  []string{"192.0.2.1"},
+		NameResolvers: []string{ResolverCache},
+	})
+
+	req := httptest.NewRequest("PUT", "/api/v1/games/names", strings.NewReader(`{"11150031899869577216": "Some Emulator"}`))
+	req.Header.Set("api-key", "key")
+	w := httptest.NewRecorder()
+	s.handler_api_v1_game_names(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d: %s", w.Code, w.Body)
+	}
+}
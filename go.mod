@@ -7,4 +7,7 @@ require (
 	golang.org/x/image v0.19.0
 )
 
-require github.com/alexflint/go-scalar v1.2.0 // indirect
+require (
+	github.com/alexflint/go-scalar v1.2.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+)
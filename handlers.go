@@ -1,11 +1,15 @@
 package steamscreenshots
 
 import (
+	"cmp"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -38,19 +42,27 @@ func SortKeysByValue(m map[string]string) []string {
 func (s *Server) handler_game(w http.ResponseWriter, r *http.Request) {
 	appid := r.PathValue("appid")
 
-	if _, exists := s.ImageCache.Games[appid]; !exists {
-		http.NotFound(w, r)
+	if primary := s.Aliases.Primary(appid); primary != appid {
+		target := "/game/" + primary + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusFound)
 		return
 	}
 
-	imageMeta := s.ImageCache.GetMetadata(appid)
+	if !s.hasGame(appid) {
+		http.NotFound(w, r)
+		return
+	}
 
-	files := []string{}
-	for _, m := range imageMeta {
-		files = append(files, m.Src)
+	imageMeta := s.gameMetadata(appid)
+	page, next, err := paginate(imageMeta, compareMetadata, r.URL.Query().Get("cursor"), s.settings.GalleryPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	sort.Strings(files)
 	pretty, err := s.getGameName(appid)
 	if err != nil {
 		fmt.Printf("Error getting name for %s: %s\n", appid, err)
@@ -60,70 +72,448 @@ func (s *Server) handler_game(w http.ResponseWriter, r *http.Request) {
 	d.Title = pretty
 	d.Header = map[string]string{
 		"Text":  pretty,
-		"Count": fmt.Sprintf("%d", len(files)),
+		"Count": fmt.Sprintf("%d", len(imageMeta)),
+		"Next":  next,
+		"More":  "/api/game/" + appid + "/images",
+
+		"Download": "/game/" + appid + "/download.zip",
 	}
-	d.Body = []map[string]template.JS{}
-	d.ImageMetadata = imageMeta
+	d.Body = galleryBody(page)
+	d.ImageMetadata = page
+	d.Highlights = s.gameFavorites(appid)
+
+	err = renderTemplate(w, "list", &d)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (s *Server) handler_favorites(w http.ResponseWriter, r *http.Request) {
+	page, err := s.favoritesPage(r.URL.Query().Get("cursor"), s.settings.GalleryPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d := TemplateData{}
+	d.Title = "Favorites"
+	d.Header = map[string]string{
+		"Text":  "Favorites",
+		"Count": fmt.Sprintf("%d", len(s.ImageCache.Favorites(""))),
+		"Next":  page.Next,
+		"More":  "/api/favorites",
+	}
+	d.Body = galleryBody(page.Items)
+	d.ImageMetadata = page.Items
+
+	err = renderTemplate(w, "list", &d)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// galleryBody returns the thumbnail grid entries for the list template.
+func galleryBody(images []Metadata) []map[string]template.JS {
+	body := []map[string]template.JS{}
 
-	for idx, filename := range files {
-		base := filepath.Base(filename)
+	for idx, md := range images {
+		badge := ""
+		if md.Video {
+			badge = formatDuration(time.Duration(md.Duration * float64(time.Second)))
+		} else if md.HDR {
+			badge = "HDR"
+		}
 		clearclass := ""
 		if idx%3 == 0 {
 			clearclass = " clearme"
-			//fmt.Printf("Clearme on %q\n", base)
+		}
+		favorite := ""
+		if md.Favorite {
+			favorite = "true"
 		}
 
-		d.Body = append(d.Body, map[string]template.JS{
-			"ImageTarget":  template.JS("/img/" + appid + "/" + base),
-			"ThumbnailSrc": template.JS("/thumb/" + appid + "/" + base),
-			"Text":         template.JS(base),
+		body = append(body, map[string]template.JS{
+			"ImageTarget":  template.JS("/img/" + md.AppId + "/" + md.Filename),
+			"ThumbnailSrc": template.JS(md.Thumb),
+			"Text":         template.JS(md.Filename),
 			"Clear":        template.JS(clearclass),
 			"Idx":          template.JS(fmt.Sprintf("%d", idx)),
+			"Badge":        template.JS(badge),
+			"Favorite":     template.JS(favorite),
 		})
 	}
 
-	err = renderTemplate(w, "list", &d)
-	if err != nil {
-		fmt.Println(err)
-	}
+	return body
+}
+
+// gameGroups are the ways the main list can be grouped, by the group query
+// parameter.  Games can be in more than one group.
+var gameGroups = map[string]func(info GameInfo) []string{
+	"genre":     func(info GameInfo) []string { return info.Genres },
+	"developer": func(info GameInfo) []string { return info.Developers },
+	"year": func(info GameInfo) []string {
+		if info.ReleaseYear == 0 {
+			return nil
+		}
+		return []string{strconv.Itoa(info.ReleaseYear)}
+	},
+	"type": func(info GameInfo) []string {
+		if info.Type == "" {
+			return nil
+		}
+		return []string{info.Type}
+	},
+}
+
+// gameSorts are the orders the main list can be in, by the sort query
+// parameter.  Ties are broken by name.
+var gameSorts = map[string]func(a, b gameEntry) int{
+	"name":  func(a, b gameEntry) int { return 0 },
+	"count": func(a, b gameEntry) int { return cmp.Compare(b.Count, a.Count) },
+	"recent": func(a, b gameEntry) int {
+		return b.LatestCapture.Compare(a.LatestCapture)
+	},
+	"first": func(a, b gameEntry) int {
+		return a.FirstCapture.Compare(b.FirstCapture)
+	},
+}
+
+// gameEntry is a game on the main list.
+type gameEntry struct {
+	AppId string
+	Name  string
+	GameSummary
 }
 
 func (s *Server) handler_main(w http.ResponseWriter, r *http.Request) {
-	keys := s.ImageCache.GetGames()
-	gameNames := map[string]string{}
+	query := r.URL.Query()
+	group := query.Get("group")
+	genre := strings.TrimSpace(query.Get("genre"))
+	source := query.Get("source")
+	favorites := query.Get("favorites") != ""
+
+	order := query.Get("sort")
+	if order == "" {
+		order = "name"
+	}
+
+	groupBy, grouped := gameGroups[group]
+	compare, sorted := gameSorts[order]
+	switch {
+	case group != "" && !grouped:
+		http.Error(w, "unknown group "+group, http.StatusBadRequest)
+		return
+	case !sorted:
+		http.Error(w, "unknown sort "+order, http.StatusBadRequest)
+		return
+	case source != "" && source != "steam" && source != "nonsteam":
+		http.Error(w, "unknown source "+source, http.StatusBadRequest)
+		return
+	}
 
 	d := TemplateData{}
+	d.Header = map[string]string{
+		"Group":     group,
+		"Genre":     genre,
+		"Sort":      order,
+		"Source":    source,
+		"Favorites": query.Get("favorites"),
+	}
 	d.Body = []map[string]template.JS{}
-	for _, k := range keys {
-		pretty, err := s.getGameName(k)
+
+	// Aliases are counted with their primary game.
+	summaries := map[string]GameSummary{}
+	for appid, sum := range s.ImageCache.Summaries() {
+		appid = s.Aliases.Primary(appid)
+		summaries[appid] = mergeSummaries(summaries[appid], sum)
+	}
+
+	// Every appid gets its own entry, even if two games have the same name.
+	games := []gameEntry{}
+	for appid, sum := range summaries {
+		if genre != "" && !s.hasGenre(appid, genre) {
+			continue
+		}
+		if (source == "steam" && isNonSteam(appid)) || (source == "nonsteam" && !isNonSteam(appid)) {
+			continue
+		}
+		if favorites && sum.Favorites == 0 {
+			continue
+		}
+
+		pretty, err := s.getGameName(appid)
 		if err != nil {
-			fmt.Printf("Error getting name for %s: %s\n", k, err)
+			fmt.Printf("Error getting name for %s: %s\n", appid, err)
+		}
+		games = append(games, gameEntry{AppId: appid, Name: pretty, GameSummary: sum})
+	}
+
+	slices.SortFunc(games, func(a, b gameEntry) int {
+		if c := compare(a, b); c != 0 {
+			return c
+		}
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.AppId, b.AppId)
+	})
+
+	if !grouped {
+		d.Body = gameTiles(games)
+	} else {
+		groups := map[string][]gameEntry{}
+		for _, game := range games {
+			info, _ := s.Games.Info(game.AppId)
+			labels := groupBy(info)
+			if len(labels) == 0 {
+				labels = []string{""}
+			}
+			for _, label := range labels {
+				groups[label] = append(groups[label], game)
+			}
+		}
+
+		labels := []string{}
+		for label := range groups {
+			if label != "" {
+				labels = append(labels, label)
+			}
 		}
-		gameNames[pretty] = k
+		sort.Sort(StringSliceNoCase(labels))
+
+		// Games the store doesn't know about go last.
+		if _, ok := groups[""]; ok {
+			labels = append(labels, "")
+		}
+
+		for _, label := range labels {
+			heading := map[string]template.JS{"Group": template.JS(label)}
+			if label == "" {
+				heading["Group"] = "Other"
+			} else if group == "genre" {
+				filter := url.Values{}
+				for k, v := range query {
+					filter[k] = v
+				}
+				filter.Set("genre", label)
+				filter.Del("group")
+				heading["Link"] = template.JS("/?" + filter.Encode())
+			}
+			d.Body = append(d.Body, heading)
+			d.Body = append(d.Body, gameTiles(groups[label])...)
+		}
+	}
+
+	err := renderTemplate(w, "main", &d)
+	if err != nil {
+		fmt.Println(err)
 	}
+}
 
-	gameKeys := []string{}
-	for k, _ := range gameNames {
-		gameKeys = append(gameKeys, k)
+func mergeSummaries(a, b GameSummary) GameSummary {
+	if a.Count == 0 {
+		return b
+	}
+	if b.Count == 0 {
+		return a
 	}
 
-	sort.Sort(StringSliceNoCase(gameKeys))
+	a.Count += b.Count
+	a.Favorites += b.Favorites
+	if b.FirstCapture.Before(a.FirstCapture) {
+		a.FirstCapture = b.FirstCapture
+	}
+	if b.LatestCapture.After(a.LatestCapture) {
+		a.LatestCapture = b.LatestCapture
+	}
+	return a
+}
 
-	for idx, pretty := range gameKeys {
+// gameTiles makes the main list's entries for games.
+func gameTiles(games []gameEntry) []map[string]template.JS {
+	tiles := []map[string]template.JS{}
+	for idx, game := range games {
 		clearclass := ""
 		if idx%3 == 0 {
 			clearclass = " clearme"
 		}
-		appid := gameNames[pretty]
-		d.Body = append(d.Body, map[string]template.JS{
-			"Target": template.JS(appid + "/"),
-			"Pretty": template.JS(pretty),
-			"Count":  template.JS(fmt.Sprintf("%d", s.ImageCache.Count(appid))),
+		tiles = append(tiles, map[string]template.JS{
+			"Target": template.JS(game.AppId + "/"),
+			"Pretty": template.JS(game.Name),
+			"Count":  template.JS(fmt.Sprintf("%d", game.Count)),
 			"Clear":  template.JS(clearclass),
 		})
 	}
+	return tiles
+}
 
-	err := renderTemplate(w, "main", &d)
+// hasGenre reports whether the store lists genre for appid.
+func (s *Server) hasGenre(appid, genre string) bool {
+	info, _ := s.Games.Info(appid)
+	for _, g := range info.Genres {
+		if strings.EqualFold(g, genre) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handler_timeline(w http.ResponseWriter, r *http.Request) {
+	page, err := s.timelinePage(r.URL.Query().Get("cursor"), DefaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d := TemplateData{}
+	d.Title = "Timeline"
+	d.Header = map[string]string{
+		"Text": "Timeline",
+		"Next": page.Next,
+	}
+	d.ImageMetadata = page.Items
+
+	err = renderTemplate(w, "timeline", &d)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (s *Server) handler_search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	d := TemplateData{}
+	d.Title = "Search"
+	d.Header = map[string]string{
+		"Text":  "Search",
+		"Query": query,
+	}
+	d.Body = []map[string]template.JS{}
+
+	if query != "" {
+		results := s.Search(query, DefaultPageSize)
+		d.Header["Games"] = fmt.Sprintf("%d", len(results.Games))
+		d.Header["Images"] = fmt.Sprintf("%d", len(results.Images))
+
+		for idx, appid := range results.Games {
+			clearclass := ""
+			if idx%3 == 0 {
+				clearclass = " clearme"
+			}
+			d.Body = append(d.Body, map[string]template.JS{
+				"Target": template.JS(appid + "/"),
+				"Pretty": template.JS(s.Games.Get(appid)),
+				"Count":  template.JS(fmt.Sprintf("%d", s.ImageCache.Count(appid))),
+				"Clear":  template.JS(clearclass),
+			})
+		}
+
+		d.Body = append(d.Body, galleryBody(results.Images)...)
+		d.ImageMetadata = results.Images
+	}
+
+	err := renderTemplate(w, "search", &d)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// handler_admin_overrides lists every game with its name and override.
+// Changes are made through the API by the page's script.
+func (s *Server) handler_admin_overrides(w http.ResponseWriter, r *http.Request) {
+	overrides := map[string]string{}
+	for _, ovr := range s.Overrides.List() {
+		overrides[ovr.Appid] = ovr.Name
+	}
+
+	appids := s.ImageCache.GetGames()
+	for appid := range overrides {
+		if !SliceContains(appids, appid) {
+			appids = append(appids, appid)
+		}
+	}
+
+	names := map[string]string{}
+	for _, appid := range appids {
+		names[appid], _ = s.getGameName(appid)
+	}
+	sort.Slice(appids, func(i, j int) bool {
+		return strings.ToLower(names[appids[i]]) < strings.ToLower(names[appids[j]])
+	})
+
+	d := TemplateData{}
+	d.Title = "Name overrides"
+	d.Header = map[string]string{
+		"Text": "Name overrides",
+	}
+	d.Body = []map[string]template.JS{}
+
+	for _, appid := range appids {
+		d.Body = append(d.Body, map[string]template.JS{
+			"AppId":    template.JS(appid),
+			"Name":     template.JS(names[appid]),
+			"Override": template.JS(overrides[appid]),
+			"Count":    template.JS(fmt.Sprintf("%d", s.ImageCache.Count(appid))),
+		})
+	}
+
+	err := renderTemplate(w, "overrides", &d)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (s *Server) handler_albums(w http.ResponseWriter, r *http.Request) {
+	d := TemplateData{}
+	d.Title = "Albums"
+	d.Header = map[string]string{
+		"Text": "Albums",
+	}
+	d.Body = []map[string]template.JS{}
+
+	for _, album := range s.Albums.List() {
+		thumb := ""
+		if cover, ok := album.CoverImage(); ok {
+			thumb = "/thumb/" + cover.AppId + "/" + cover.Filename
+		}
+
+		d.Body = append(d.Body, map[string]template.JS{
+			"Id":        template.JS(album.Id),
+			"Name":      template.JS(album.Name),
+			"Count":     template.JS(fmt.Sprintf("%d", len(s.albumMetadata(album)))),
+			"Thumbnail": template.JS(thumb),
+		})
+	}
+
+	err := renderTemplate(w, "albums", &d)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// handler_album shows an album using the same gallery as a game's page.
+// Albums are shown in full instead of being paginated since they're in a
+// user defined order.
+func (s *Server) handler_album(w http.ResponseWriter, r *http.Request) {
+	album, ok := s.Albums.Get(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	images := s.albumMetadata(album)
+
+	d := TemplateData{}
+	d.Title = album.Name
+	d.Header = map[string]string{
+		"Text":        album.Name,
+		"Count":       fmt.Sprintf("%d", len(images)),
+		"Description": album.Description,
+	}
+	d.Body = galleryBody(images)
+	d.ImageMetadata = images
+
+	err := renderTemplate(w, "list", &d)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -133,7 +523,7 @@ func (s *Server) handler_thumb(w http.ResponseWriter, r *http.Request) {
 	appid    := r.PathValue("appid")
 	filename := r.PathValue("filename")
 
-	if _, exists := s.ImageCache.Games[appid][filename]; !exists {
+	if _, exists := s.ImageCache.GetImage(appid, filename); !exists {
 		http.NotFound(w, r)
 		return
 	}
@@ -145,6 +535,31 @@ func (s *Server) handler_thumb(w http.ResponseWriter, r *http.Request) {
 		filename,
 	)
 
+	// Thumbnails are always JPEG, regardless of the original's extension.
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, fullPath)
+}
+
+func (s *Server) handler_preview(w http.ResponseWriter, r *http.Request) {
+	appid    := r.PathValue("appid")
+	filename := r.PathValue("filename")
+
+	s.ImageCache.lock.RLock()
+	meta, exists := s.ImageCache.Games[appid][filename]
+	s.ImageCache.lock.RUnlock()
+	if !exists || !meta.Preview {
+		http.NotFound(w, r)
+		return
+	}
+
+	fullPath := filepath.Join(
+		s.settings.ImageDirectory,
+		appid,
+		"previews",
+		filename,
+	)
+
+	w.Header().Set("Content-Type", "image/jpeg")
 	http.ServeFile(w, r, fullPath)
 }
 
@@ -153,9 +568,12 @@ func (s *Server) handler_image(w http.ResponseWriter, r *http.Request) {
 	filename := r.PathValue("filename")
 
 	if filename == "banner.jpg" {
-		if _, exists := s.ImageCache.Games[appid]; exists {
-			bannerpath, err := s.getGameBanner(appid)
-			if err != nil {
+		if s.hasGame(appid) {
+			bannerpath, ok := s.Banners.Get(appid)
+			if !ok {
+				bannerpath, ok = s.generatedBanner(appid)
+			}
+			if !ok {
 				http.ServeFileFS(w, r, s.StaticFiles, "banners/unknown.jpg")
 				return
 			}
@@ -168,7 +586,7 @@ func (s *Server) handler_image(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if _, exists := s.ImageCache.Games[appid][filename]; !exists {
+	if _, exists := s.ImageCache.GetImage(appid, filename); !exists {
 		http.NotFound(w, r)
 		return
 	}
@@ -179,6 +597,16 @@ func (s *Server) handler_image(w http.ResponseWriter, r *http.Request) {
 		filename,
 	)
 
+	if format := FormatFor(filename); format != nil {
+		w.Header().Set("Content-Type", format.MimeType)
+
+		// Clips can take a lot longer to send than the server's write
+		// timeout.  Range requests are handled by ServeFile.
+		if format.IsVideo() {
+			http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+	}
+
 	http.ServeFile(w, r, fullPath)
 }
 
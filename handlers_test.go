@@ -0,0 +1,159 @@
+package steamscreenshots
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMainList(t *testing.T) {
+	if err := init_templates(); err != nil {
+		t.Fatal(err)
+	}
+
+	games := newTestGameList(t)
+	s := newTestServer(t, newFakeSteam(t), games, Settings{NameResolvers: []string{ResolverCache}})
+	s.ImageCache = NewGameImages()
+
+	now := time.Now()
+	s.ImageCache.Games["70"] = map[string]*ImageMeta{"a.jpg": {CapturedAt: now}}
+	s.ImageCache.Games["130"] = map[string]*ImageMeta{
+		"a.jpg": {CapturedAt: now.Add(-time.Hour), Favorite: true},
+		"b.jpg": {CapturedAt: now.Add(-2 * time.Hour)},
+	}
+	games.Set("70", "Half-Life")
+	games.Set("130", "Half-Life")
+
+	list := func(target string) string {
+		w := httptest.NewRecorder()
+		s.handler_main(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != 200 {
+			t.Fatalf("%s: %d %s", target, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+
+	// Games with the same name are both listed.
+	body := list("/")
+	if !strings.Contains(body, `href="/game/70/"`) || !strings.Contains(body, `href="/game/130/"`) {
+		t.Error("games with the same name weren't both listed")
+	}
+
+	body = list("/?sort=count")
+	if strings.Index(body, `href="/game/130/"`) > strings.Index(body, `href="/game/70/"`) {
+		t.Error("not sorted by count")
+	}
+
+	if body = list("/?favorites=1"); strings.Contains(body, `href="/game/70/"`) {
+		t.Error("game without favorites listed")
+	}
+}
+
+// Run with -race.  Images are served while they're being edited.
+func TestImageHandlersConcurrentEdits(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, newFakeSteam(t), newTestGameList(t), Settings{
+		ImageDirectory: dir,
+		ApiKey:         "key",
+		ApiWhitelist:   []string{"192.0.2.1"},
+		NameResolvers:  []string{ResolverCache},
+	})
+	s.ImageCache = NewGameImages()
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{"a.jpg": {}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.ImageCache.EditImage("220", "a.jpg", func(meta *ImageMeta) { meta.Favorite = !meta.Favorite })
+			s.ImageCache.addEntry("220", fmt.Sprintf("%d.jpg", i), &ImageMeta{}, nil)
+		}
+	}()
+
+	handlers := map[string]http.HandlerFunc{
+		"/img/220/a.jpg":   s.handler_image,
+		"/thumb/220/a.jpg": s.handler_thumb,
+		"/api/get-cache":   s.handler_api_cache,
+	}
+	for i := 0; i < 100; i++ {
+		for target, handler := range handlers {
+			req := httptest.NewRequest("GET", target, nil)
+			req.SetPathValue("appid", "220")
+			req.SetPathValue("filename", "a.jpg")
+			req.Header.Set("api-key", "key")
+			handler(httptest.NewRecorder(), req)
+		}
+	}
+	<-done
+}
+
+func TestFavorites(t *testing.T) {
+	if err := init_templates(); err != nil {
+		t.Fatal(err)
+	}
+
+	games := newTestGameList(t)
+	games.Set("220", "Half-Life 2")
+	games.Set("70", "Half-Life")
+	s := newTestServer(t, newFakeSteam(t), games, Settings{NameResolvers: []string{ResolverCache}, GalleryPageSize: 10})
+
+	now := time.Now()
+	s.ImageCache = NewGameImages()
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{
+		"a.jpg": {CapturedAt: now.Add(-2 * time.Hour), Favorite: true},
+		"b.jpg": {CapturedAt: now},
+	}
+	s.ImageCache.Games["221"] = map[string]*ImageMeta{"c.jpg": {CapturedAt: now.Add(-time.Hour), Favorite: true}}
+	s.ImageCache.Games["70"] = map[string]*ImageMeta{
+		"d.jpg": {CapturedAt: now.Add(-3 * time.Hour), Favorite: true},
+		"e.jpg": {CapturedAt: now.Add(-4 * time.Hour)},
+	}
+	s.ImageCache.Games["130"] = map[string]*ImageMeta{"f.jpg": {CapturedAt: now}}
+	if _, _, err := s.Aliases.Set("221", "220"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every starred image, newest first, with its game's name.
+	page, err := s.favoritesPage("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Filename != "c.jpg" || page.Items[1].Filename != "a.jpg" || page.Items[1].Game != "Half-Life 2" {
+		t.Fatalf("unexpected first page: %+v", page.Items)
+	}
+	if page, err = s.favoritesPage(page.Next, 2); err != nil || len(page.Items) != 1 || page.Items[0].Filename != "d.jpg" || page.Next != "" {
+		t.Errorf("unexpected last page: %+v, %v", page, err)
+	}
+
+	w := httptest.NewRecorder()
+	s.handler_favorites(w, httptest.NewRequest("GET", "/favorites", nil))
+	if body := w.Body.String(); w.Code != 200 || !strings.Contains(body, "/thumb/221/c.jpg") || strings.Contains(body, "/thumb/220/b.jpg") {
+		t.Errorf("unexpected favorites page: %d %s", w.Code, body)
+	}
+
+	// The best of strip includes the aliases' favorites and is left out
+	// for games without any.
+	game := func(appid string) string {
+		req := httptest.NewRequest("GET", "/game/"+appid+"/", nil)
+		req.SetPathValue("appid", appid)
+		w := httptest.NewRecorder()
+		s.handler_game(w, req)
+		if w.Code != 200 {
+			t.Fatalf("%s: %d %s", appid, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+
+	if highlights := s.gameFavorites("220"); len(highlights) != 2 || highlights[0].Filename != "c.jpg" {
+		t.Errorf("unexpected highlights: %+v", highlights)
+	}
+	if body := game("220"); !strings.Contains(body, `id="bestof"`) {
+		t.Error("best of strip missing")
+	}
+	if body := game("130"); strings.Contains(body, `id="bestof"`) {
+		t.Error("best of strip shown for a game without favorites")
+	}
+}
@@ -0,0 +1,338 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Transfer characteristics as defined in ITU-T H.273.  These are what the
+// cICP chunk in PNG and the nclx colour box in AVIF carry.
+const (
+	transferPQ  uint8 = 16
+	transferHLG uint8 = 18
+
+	primariesBT2020 uint8 = 9
+)
+
+// Reference white for SDR content in an HDR signal, in nits (ITU-R BT.2408).
+const hdrReferenceWhite = 203.0
+
+type hdrInfo struct {
+	HDR       bool
+	Transfer  uint8
+	Primaries uint8
+}
+
+// pngHDRInfo looks for a cICP chunk with a PQ or HLG transfer function.
+func pngHDRInfo(r io.Reader) (hdrInfo, error) {
+	// cICP must come before the image data
+	chunks, err := findPngChunks(r, true, "cICP")
+	if err != nil || len(chunks["cICP"]) < 2 {
+		return hdrInfo{}, err
+	}
+	return newHDRInfo(chunks["cICP"][0], chunks["cICP"][1]), nil
+}
+
+// maxPngChunk limits the chunks findPngChunks keeps.  The ones it's used for
+// are tiny, or a compressed ICC profile in the case of iCCP.
+const maxPngChunk = 1 << 20
+
+// findPngChunks returns the data of the first chunk of each of the given
+// types that is found.  If beforeData is true the search stops at the first
+// IDAT chunk.
+func findPngChunks(r io.Reader, beforeData bool, types ...string) (map[string][]byte, error) {
+	sig := make([]byte, 8)
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+
+	if string(sig) != "\x89PNG\r\n\x1a\n" {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+
+	found := make(map[string][]byte)
+	hdr := make([]byte, 8)
+	for len(found) < len(types) {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return nil, err
+		}
+
+		length := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+
+		// The spec caps chunks at 2^31-1 bytes.
+		if length > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid length for %q chunk: %d", typ, length)
+		}
+
+		if typ == "IEND" || (beforeData && typ == "IDAT") {
+			break
+		}
+
+		if _, seen := found[typ]; !seen && slices.Contains(types, typ) {
+			if length > maxPngChunk {
+				return nil, fmt.Errorf("%q chunk is too large: %d bytes", typ, length)
+			}
+
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			found[typ] = data
+			length = 0
+		}
+
+		// skip the data and CRC
+		if err := skipBytes(r, length+4); err != nil {
+			return nil, err
+		}
+	}
+
+	return found, nil
+}
+
+// avifHDRInfo reads the nclx colour information from an AVIF file.
+func avifHDRInfo(r io.Reader) (hdrInfo, error) {
+	boxes, err := readBmffBoxes(r, "meta")
+	if err != nil {
+		return hdrInfo{}, err
+	}
+
+	for _, colr := range bmffFind(boxes, "meta", "iprp", "ipco", "colr") {
+		if len(colr.Data) < 10 || string(colr.Data[0:4]) != "nclx" {
+			continue
+		}
+
+		primaries := binary.BigEndian.Uint16(colr.Data[4:6])
+		transfer := binary.BigEndian.Uint16(colr.Data[6:8])
+		return newHDRInfo(uint8(primaries), uint8(transfer)), nil
+	}
+
+	return hdrInfo{}, nil
+}
+
+// jxrHDRInfo marks every JPEG XR file as HDR.  That's the format Windows
+// uses for HDR captures and there's no reason to use it otherwise.
+func jxrHDRInfo(r io.Reader) (hdrInfo, error) {
+	return hdrInfo{HDR: true}, nil
+}
+
+func newHDRInfo(primaries, transfer uint8) hdrInfo {
+	return hdrInfo{
+		HDR:       transfer == transferPQ || transfer == transferHLG,
+		Transfer:  transfer,
+		Primaries: primaries,
+	}
+}
+
+// jxrConfig reads the dimensions from the JPEG XR container, which is a
+// TIFF-like IFD structure.
+func jxrConfig(r io.Reader) (image.Config, error) {
+	// The IFD is at the start of the file, before the image data.
+	data, err := io.ReadAll(io.LimitReader(r, 64*1024))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	if len(data) < 8 || !bytes.Equal(data[0:3], []byte{'I', 'I', 0xBC}) {
+		return image.Config{}, fmt.Errorf("not a JPEG XR file")
+	}
+
+	order := binary.LittleEndian
+	entries, err := readIFD(data, order, order.Uint32(data[4:8]))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	width, okw := entries[0xBC80].Uint(order)
+	height, okh := entries[0xBC81].Uint(order)
+	if !okw || !okh {
+		return image.Config{}, fmt.Errorf("missing image dimensions")
+	}
+
+	return image.Config{Width: int(width), Height: int(height)}, nil
+}
+
+// toneMap converts an HDR image to SDR.  Values are decoded with the image's
+// transfer function, converted to BT.709 primaries if needed, and compressed
+// into the SDR range with an extended Reinhard curve on luminance.
+func toneMap(img image.Image, info hdrInfo) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	// Brightest value that maps to SDR white, relative to reference white.
+	// Most games master for a 1000 nit display.
+	peak := 1000.0 / hdrReferenceWhite
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			rgb := [3]float64{
+				float64(c.R) / 0xFFFF,
+				float64(c.G) / 0xFFFF,
+				float64(c.B) / 0xFFFF,
+			}
+
+			for i := range rgb {
+				switch info.Transfer {
+				case transferPQ:
+					rgb[i] = pqToNits(rgb[i]) / hdrReferenceWhite
+				case transferHLG:
+					rgb[i] = hlgToLinear(rgb[i]) * 1000.0 / hdrReferenceWhite
+				}
+			}
+
+			if info.Primaries == primariesBT2020 {
+				rgb = bt2020To709(rgb)
+			}
+
+			lum := 0.2126*rgb[0] + 0.7152*rgb[1] + 0.0722*rgb[2]
+			if lum > 0 {
+				mapped := lum * (1 + lum/(peak*peak)) / (1 + lum)
+				for i := range rgb {
+					rgb[i] *= mapped / lum
+				}
+			}
+
+			out.SetRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.RGBA{
+				R: linearToSRGB(rgb[0]),
+				G: linearToSRGB(rgb[1]),
+				B: linearToSRGB(rgb[2]),
+				A: uint8(c.A >> 8),
+			})
+		}
+	}
+
+	return out
+}
+
+// pqToNits is the SMPTE ST 2084 EOTF.
+func pqToNits(e float64) float64 {
+	const (
+		m1 = 2610.0 / 16384.0
+		m2 = 2523.0 / 4096.0 * 128.0
+		c1 = 3424.0 / 4096.0
+		c2 = 2413.0 / 4096.0 * 32.0
+		c3 = 2392.0 / 4096.0 * 32.0
+	)
+
+	ep := math.Pow(e, 1/m2)
+	return math.Pow(math.Max(ep-c1, 0)/(c2-c3*ep), 1/m1) * 10000.0
+}
+
+// hlgToLinear is the inverse of the ARIB STD-B67 OETF, returning scene
+// linear light in the range 0-1.
+func hlgToLinear(e float64) float64 {
+	const (
+		a = 0.17883277
+		b = 1 - 4*a
+	)
+	c := 0.5 - a*math.Log(4*a)
+
+	if e <= 0.5 {
+		return e * e / 3
+	}
+	return (math.Exp((e-c)/a) + b) / 12
+}
+
+func bt2020To709(rgb [3]float64) [3]float64 {
+	return [3]float64{
+		1.6605*rgb[0] - 0.5876*rgb[1] - 0.0728*rgb[2],
+		-0.1246*rgb[0] + 1.1329*rgb[1] - 0.0083*rgb[2],
+		-0.0182*rgb[0] - 0.1006*rgb[1] + 1.1187*rgb[2],
+	}
+}
+
+func linearToSRGB(v float64) uint8 {
+	v = math.Min(math.Max(v, 0), 1)
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(v * 255))
+}
+
+// sdrSiblingExts are tried in order when looking for the SDR copy Steam saves
+// next to an HDR image.
+var sdrSiblingExts = []string{".jpg", ".jpeg", ".png", ".JPG", ".JPEG", ".PNG"}
+
+// sdrImage returns an SDR version of the HDR image at fullpath.  If the
+// format can be decoded the image is tone mapped.  Otherwise an SDR file
+// with the same base name is used, as Steam saves one of those next to each
+// HDR capture.  Nil is returned if neither is available.
+func sdrImage(fullpath string, format *ImageFormat, info hdrInfo) (image.Image, error) {
+	if format.Decode != nil {
+		file, err := os.Open(fullpath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		img, err := format.Decode(file)
+		if err != nil {
+			return nil, err
+		}
+		return toneMap(img, info), nil
+	}
+
+	base := strings.TrimSuffix(fullpath, filepath.Ext(fullpath))
+	for _, ext := range sdrSiblingExts {
+		sibling := FormatFor(base + ext)
+		file, err := os.Open(base + ext)
+		if err != nil {
+			continue
+		}
+
+		img, err := sibling.Decode(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		return img, nil
+	}
+
+	return nil, nil
+}
+
+// sdrSiblings maps the SDR copies in a game to the HDR images they were
+// saved next to.  They're hidden from galleries so the capture isn't shown
+// twice, but can still be opened directly.
+func sdrSiblings(game map[string]*ImageMeta) map[string]string {
+	siblings := make(map[string]string)
+	for filename, meta := range game {
+		if !meta.HDR {
+			continue
+		}
+
+		base := strings.TrimSuffix(filename, filepath.Ext(filename))
+		for _, ext := range sdrSiblingExts {
+			sibling, ok := game[base+ext]
+			if ok && base+ext != filename && !sibling.HDR {
+				siblings[base+ext] = filename
+				break
+			}
+		}
+	}
+	return siblings
+}
+
+// moveSiblingFavorites stars the HDR image instead of its hidden SDR copy,
+// so the favorite stays visible.
+func moveSiblingFavorites(game map[string]*ImageMeta) {
+	for sibling, hdr := range sdrSiblings(game) {
+		if game[sibling].Favorite {
+			game[sibling].Favorite = false
+			game[hdr].Favorite = true
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package steamscreenshots
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Minimal TIFF style IFD reader.  JPEG XR files use a TIFF-like container
+// and EXIF data is stored the same way, so this is shared between the two.
+
+type ifdEntry struct {
+	Tag    uint16
+	Type   uint16
+	Count  uint32
+	Value  []byte // raw value bytes, in the file's byte order
+	offset uint32 // value/offset field as stored
+}
+
+// Uint returns the first value of a BYTE, SHORT or LONG entry.
+func (e ifdEntry) Uint(order binary.ByteOrder) (uint32, bool) {
+	switch e.Type {
+	case 1: // BYTE
+		if len(e.Value) >= 1 {
+			return uint32(e.Value[0]), true
+		}
+	case 3: // SHORT
+		if len(e.Value) >= 2 {
+			return uint32(order.Uint16(e.Value)), true
+		}
+	case 4: // LONG
+		if len(e.Value) >= 4 {
+			return order.Uint32(e.Value), true
+		}
+	}
+	return 0, false
+}
+
+// String returns the value of an ASCII entry without the trailing NUL.
+func (e ifdEntry) String() string {
+	val := e.Value
+	for len(val) > 0 && val[len(val)-1] == 0 {
+		val = val[:len(val)-1]
+	}
+	return string(val)
+}
+
+// ifdTypeSizes holds the byte size of a single value for each field type.
+var ifdTypeSizes = map[uint16]uint32{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+	11: 4, // FLOAT
+	12: 8, // DOUBLE
+}
+
+// readIFD parses the IFD starting at offset in data.  Offsets are relative
+// to the start of data.
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) (map[uint16]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, fmt.Errorf("IFD offset out of range")
+	}
+
+	count := int(order.Uint16(data[offset:]))
+	entries := make(map[uint16]ifdEntry)
+	pos := offset + 2
+
+	for i := 0; i < count; i++ {
+		if uint64(pos)+12 > uint64(len(data)) {
+			return nil, fmt.Errorf("IFD entry out of range")
+		}
+
+		e := ifdEntry{
+			Tag:    order.Uint16(data[pos:]),
+			Type:   order.Uint16(data[pos+2:]),
+			Count:  order.Uint32(data[pos+4:]),
+			offset: order.Uint32(data[pos+8:]),
+		}
+
+		size := uint64(ifdTypeSizes[e.Type]) * uint64(e.Count)
+		if size <= 4 {
+			e.Value = data[pos+8 : uint64(pos)+8+size]
+		} else if uint64(e.offset)+size <= uint64(len(data)) {
+			e.Value = data[e.offset : uint64(e.offset)+size]
+		}
+
+		entries[e.Tag] = e
+		pos += 12
+	}
+
+	return entries, nil
+}
@@ -7,14 +7,13 @@ import (
 	"time"
 	"image"
 	"image/jpeg"
-	_ "image/png"
 	"path/filepath"
 	"errors"
 	"encoding/json"
 	"slices"
 	"strings"
-
-	"golang.org/x/image/draw"
+	"strconv"
+	"cmp"
 )
 
 const (
@@ -30,12 +29,77 @@ type GameImages struct {
 
 	isDirty bool
 	filename string
+	version  uint64 // incremented on every change
 }
 
 type ImageMeta struct {
 	Width  int
 	Height int
 	ModTime time.Time
+
+	// When the screenshot was taken.  See captureTime().
+	CapturedAt time.Time
+
+	HDR     bool // Original is HDR
+	Preview bool // A tone mapped SDR preview exists in previews/
+
+	Video    bool
+	Duration time.Duration
+
+	// User data, edited through the API.  Kept when the file is rescanned or
+	// uploaded again.
+	Caption  string   `json:",omitempty"`
+	Tags     []string `json:",omitempty"`
+	Favorite bool     `json:",omitempty"`
+
+	// Details from Steam's screenshots.vdf, sent by the uploader.
+	Steam *SteamScreenshot `json:",omitempty"`
+}
+
+// SteamScreenshot is what Steam knows about a screenshot.
+type SteamScreenshot struct {
+	Caption         string    `json:",omitempty"`
+	Location        string    `json:",omitempty"` // map or area, if the game reports one
+	PublishedFileId string    `json:",omitempty"` // set once uploaded to the Steam community
+	Created         time.Time
+}
+
+// keepUserData copies the user editable fields and Steam's details from a
+// previous entry for the same file.
+func (meta *ImageMeta) keepUserData(old *ImageMeta) {
+	if old == nil {
+		return
+	}
+
+	meta.Caption = old.Caption
+	meta.Tags = old.Tags
+	meta.Favorite = old.Favorite
+
+	if old.Steam != nil {
+		meta.Steam = old.Steam
+		meta.useSteamCreated()
+	}
+}
+
+// setSteam adds Steam's details from an upload.  Steam's caption is only used
+// if the image doesn't have one already.
+func (meta *ImageMeta) setSteam(steam *SteamScreenshot) {
+	if steam == nil {
+		return
+	}
+
+	meta.Steam = steam
+	if meta.Caption == "" {
+		meta.Caption = steam.Caption
+	}
+	meta.useSteamCreated()
+}
+
+// useSteamCreated replaces the guessed capture time with Steam's.
+func (meta *ImageMeta) useSteamCreated() {
+	if !meta.Steam.Created.IsZero() {
+		meta.CapturedAt = meta.Steam.Created
+	}
 }
 
 // Used in TemplateData
@@ -43,6 +107,27 @@ type Metadata struct {
 	Src    string `json:"src"`
 	Width  int    `json:"w"`
 	Height int    `json:"h"`
+
+	// HDR images are displayed using their SDR preview in Src.  Original
+	// points to the HDR file for downloading.
+	HDR      bool   `json:"hdr,omitempty"`
+	Original string `json:"original,omitempty"`
+
+	AppId      string    `json:"appid"`
+	Filename   string    `json:"filename"`
+	Thumb      string    `json:"thumb"`
+	CapturedAt time.Time `json:"captured"`
+	Game       string    `json:"game,omitempty"` // only filled in when mixing games
+
+	// Video clips are turned into HTML slides by the gallery script.
+	Video    bool    `json:"video,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+
+	Caption  string   `json:"caption,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Favorite bool     `json:"favorite,omitempty"`
+	Location string   `json:"location,omitempty"`
+	SteamUrl string   `json:"steam,omitempty"` // Steam community page
 }
 
 func LoadImageCache(filename, rootDir string) (*GameImages, error) {
@@ -77,12 +162,6 @@ func NewGameImages() *GameImages {
 	}
 }
 
-var supportedImageFormats []string = []string{
-	".jpeg",
-	".jpg",
-	".png",
-}
-
 func (s *Server) imageAdder() {
 	for {
 		img := <- s.newImages
@@ -92,23 +171,45 @@ func (s *Server) imageAdder() {
 			continue
 		}
 
+		// unsupported file
+		if meta == nil {
+			continue
+		}
+
 		fmt.Printf("adding image [%s] %s\n", img.AppId, img.Filename)
-		s.ImageCache.lock.Lock()
-		if _, ok := s.ImageCache.Games[img.AppId]; !ok {
-			s.ImageCache.Games[img.AppId] = make(map[string]*ImageMeta)
+		if err = s.ImageCache.addEntry(img.AppId, img.Filename, meta, img.Steam); err != nil {
+			fmt.Println("unable to save image cache:", err)
 		}
-		s.ImageCache.Games[img.AppId][img.Filename] = meta
-		s.ImageCache.lock.Unlock()
 	}
 }
 
+// addEntry puts an uploaded image in the index and saves it, so the Steam
+// data sent with the upload isn't lost on restart.
+func (gi *GameImages) addEntry(appid, filename string, meta *ImageMeta, steam *SteamScreenshot) error {
+	gi.lock.Lock()
+	defer gi.lock.Unlock()
+
+	if _, ok := gi.Games[appid]; !ok {
+		gi.Games[appid] = make(map[string]*ImageMeta)
+	}
+	meta.keepUserData(gi.Games[appid][filename])
+	meta.setSteam(steam)
+	gi.Games[appid][filename] = meta
+	moveSiblingFavorites(gi.Games[appid])
+	gi.version++
+
+	if gi.filename == "" {
+		return nil
+	}
+	return gi.save()
+}
+
 func (gi *GameImages) AddImage(appid, filename string) (*ImageMeta, error) {
 	fname := filename
 	dname := appid
 
-	fmt.Printf("AddImage(%q, %q)\n", appid, filename)
-
-	if !slices.Contains(supportedImageFormats, filepath.Ext(fname)) {
+	format := FormatFor(fname)
+	if format == nil {
 		fmt.Println("Unsupported image format:", filepath.Ext(fname))
 		return nil, nil
 	}
@@ -118,7 +219,14 @@ func (gi *GameImages) AddImage(appid, filename string) (*ImageMeta, error) {
 		return nil, err
 	}
 
-	cfg, _, err := image.DecodeConfig(imgFile)
+	var cfg image.Config
+	var video videoInfo
+	if format.IsVideo() {
+		video, err = format.Probe(imgFile)
+		cfg = image.Config{Width: video.Width, Height: video.Height}
+	} else {
+		cfg, err = format.DecodeConfig(imgFile)
+	}
 	imgFile.Close()
 	if err != nil {
 		return nil, err
@@ -133,49 +241,119 @@ func (gi *GameImages) AddImage(appid, filename string) (*ImageMeta, error) {
 		Width:   cfg.Width,
 		Height:  cfg.Height,
 		ModTime: info.ModTime(),
+
+		Video:    format.IsVideo(),
+		Duration: video.Duration,
+	}
+
+	meta.CapturedAt = captureTime(filepath.Join(gi.Root, dname, fname), format, info.ModTime())
+
+	var hdr hdrInfo
+	if format.HDRInfo != nil {
+		imgFile, err = os.Open(filepath.Join(gi.Root, dname, fname))
+		if err != nil {
+			return nil, err
+		}
+
+		hdr, err = format.HDRInfo(imgFile)
+		imgFile.Close()
+		if err != nil {
+			return nil, err
+		}
+		meta.HDR = hdr.HDR
 	}
 
 	// make sure thumbnail exists
 	// TODO: make sure this has a .jpg extension
-	_, err = os.Stat(filepath.Join(gi.Root, dname, "thumbnails", fname))
-	if err == nil {
+	thumbPath := filepath.Join(gi.Root, dname, "thumbnails", fname)
+	previewPath := filepath.Join(gi.Root, dname, "previews", fname)
+	if exists(thumbPath) {
+		meta.Preview = meta.HDR && exists(previewPath)
 		return meta, nil
 	}
 
-	imgFile, err = os.Open(filepath.Join(gi.Root, dname, fname))
-	if err != nil {
-		return nil, err
+	var thumbImg image.Image
+	if meta.Video {
+		thumbImg = videoPoster(video, ThumbWidth)
+	} else if meta.HDR {
+		// Browsers can't be trusted to display HDR files properly, so
+		// generate a tone mapped preview along with the thumbnail.
+		sdr, err := sdrImage(filepath.Join(gi.Root, dname, fname), format, hdr)
+		if err != nil {
+			return nil, err
+		}
+
+		if sdr != nil {
+			err = writeJpeg(previewPath, sdr, &jpeg.Options{Quality: 90})
+			if err != nil {
+				return nil, err
+			}
+			meta.Preview = true
+			thumbImg = scaleThumbnail(sdr, ThumbWidth)
+		} else {
+			thumbImg = placeholderThumbnail(cfg, ThumbWidth, "HDR "+format.Name)
+		}
+	} else {
+		imgFile, err = os.Open(filepath.Join(gi.Root, dname, fname))
+		if err != nil {
+			return nil, err
+		}
+
+		thumbImg, err = format.ThumbnailFor(imgFile, cfg)
+		imgFile.Close()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	img, _, err := image.Decode(imgFile)
-	imgFile.Close()
+	err = writeJpeg(thumbPath, thumbImg, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	ratio := float64(img.Bounds().Max.Y) / float64(img.Bounds().Max.X)
-	height := int(float64(ThumbWidth) * ratio)
-	thumbImg := image.NewRGBA(image.Rect(0, 0, ThumbWidth, height))
-	draw.ApproxBiLinear.Scale(thumbImg, thumbImg.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return meta, nil
+}
 
-	err = os.MkdirAll(filepath.Join(gi.Root, dname, "thumbnails"), 0755)
-	if err != nil {
-		return nil, err
+// captureTime figures out when a screenshot was taken.  The time in Steam's
+// filename is preferred, then the file's own metadata, then the mtime.
+func captureTime(fullpath string, format *ImageFormat, modTime time.Time) time.Time {
+	if t, ok := captureTimeFromFilename(fullpath); ok {
+		return t
 	}
 
-	// TODO: make sure this has a .jpg extension
-	thumbFile, err := os.Create(filepath.Join(gi.Root, dname, "thumbnails", fname))
+	if format.CaptureTime != nil {
+		file, err := os.Open(fullpath)
+		if err != nil {
+			return modTime
+		}
+		defer file.Close()
+
+		t, err := format.CaptureTime(file)
+		if err != nil {
+			fmt.Printf("unable to read capture time from %s: %s\n", fullpath, err)
+		} else if !t.IsZero() {
+			return t
+		}
+	}
+
+	return modTime
+}
+
+// writeJpeg encodes img to filename, creating the parent directory if needed.
+func writeJpeg(filename string, img image.Image, opts *jpeg.Options) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = jpeg.Encode(thumbFile, thumbImg, nil)
-	thumbFile.Close()
+	file, err := os.Create(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return meta, nil
+	err = jpeg.Encode(file, img, opts)
+	file.Close()
+	return err
 }
 
 func (gi *GameImages) Scan() error {
@@ -205,15 +383,20 @@ func (gi *GameImages) Scan() error {
 		}
 
 		dmap := make(map[string]*ImageMeta)
+		failed := []string{}
 
 		for _, file := range files {
 			if file.IsDir() {
 				continue
 			}
 
+			// One unreadable file shouldn't keep the rest from being
+			// indexed.  It's tried again on the next scan.
 			meta, err := gi.AddImage(dname, file.Name())
 			if err != nil {
-				return err
+				fmt.Printf("unable to add %s: %s\n", filepath.Join(dname, file.Name()), err)
+				failed = append(failed, file.Name())
+				continue
 			}
 
 			if meta != nil {
@@ -223,9 +406,23 @@ func (gi *GameImages) Scan() error {
 
 		gi.lock.Lock()
 
+		for name, meta := range dmap {
+			meta.keepUserData(gi.Games[dname][name])
+		}
+
+		// Keep what's known about files that couldn't be read this time.
+		for _, name := range failed {
+			if old, ok := gi.Games[dname][name]; ok {
+				dmap[name] = old
+			}
+		}
+
+		moveSiblingFavorites(dmap)
+
 		// TODO: delete thumbnail files for images that no longer exist?
 		gi.Games[dname] = dmap
 		gi.Updated = time.Now()
+		gi.version++
 
 		gi.lock.Unlock()
 	}
@@ -241,9 +438,26 @@ func (gi *GameImages) Scan() error {
 		if _, exists := foundGames[game]; !exists {
 			fmt.Println("game id", game, "no longer exists")
 			delete(gi.Games, game)
+			gi.version++
 		}
 	}
 
+	return gi.save()
+}
+
+// Save writes the index to the cache file.
+func (gi *GameImages) Save() error {
+	if gi.filename == "" {
+		return nil
+	}
+
+	gi.lock.Lock()
+	defer gi.lock.Unlock()
+	return gi.save()
+}
+
+// save expects the lock to be held.
+func (gi *GameImages) save() error {
 	cachefile, err := os.Create(gi.filename)
 	if err != nil {
 		return err
@@ -255,6 +469,43 @@ func (gi *GameImages) Scan() error {
 	return enc.Encode(gi)
 }
 
+// EditImage applies edit to an image's user data and saves the index.  The
+// updated metadata is returned, or false if the image doesn't exist.  Nothing
+// is changed if the index can't be saved.
+func (gi *GameImages) EditImage(appid, filename string, edit func(meta *ImageMeta)) (Metadata, bool, error) {
+	gi.lock.Lock()
+	defer gi.lock.Unlock()
+
+	game := gi.Games[appid]
+	meta, ok := game[filename]
+	if !ok {
+		return Metadata{}, false, nil
+	}
+
+	// Moving a favorite can change the image's HDR sibling too.
+	before := make(map[string]ImageMeta, len(game))
+	for name, m := range game {
+		before[name] = *m
+	}
+
+	edit(meta)
+	moveSiblingFavorites(game)
+	gi.version++
+
+	md := newMetadata(appid, filename, meta)
+	if gi.filename == "" {
+		return md, true, nil
+	}
+
+	if err := gi.save(); err != nil {
+		for name, m := range before {
+			*game[name] = m
+		}
+		return Metadata{}, true, err
+	}
+	return md, true, nil
+}
+
 func (gi *GameImages) GetGames() []string {
 	gi.lock.RLock()
 	defer gi.lock.RUnlock()
@@ -269,30 +520,197 @@ func (gi *GameImages) GetGames() []string {
 func (gi *GameImages) GetMetadata(appid string) []Metadata {
 	images := []Metadata{}
 
+	gi.lock.RLock()
+	defer gi.lock.RUnlock()
+
 	theGame, ok := gi.Games[appid]
 	if !ok {
 		fmt.Printf("[GetMetadata] Unable to find game with appid %s\n", appid)
 		return nil
 	}
+	hidden := sdrSiblings(theGame)
+	for filename, meta := range theGame {
+		if _, ok := hidden[filename]; ok {
+			continue
+		}
+		images = append(images, newMetadata(appid, filename, meta))
+	}
+
+	slices.SortFunc(images, compareMetadata)
+	return images
+}
 
+// GetImage returns the metadata for a single image.
+func (gi *GameImages) GetImage(appid, filename string) (Metadata, bool) {
 	gi.lock.RLock()
 	defer gi.lock.RUnlock()
-	for filename, meta := range theGame {
-		images = append(images, Metadata{
-			// FIXME: oh god why
-			Src:    fmt.Sprintf("/img/%s/%s", appid, filename),
-			Width:  meta.Width,
-			Height: meta.Height,
-		})
+
+	meta, ok := gi.Games[appid][filename]
+	if !ok {
+		return Metadata{}, false
+	}
+	return newMetadata(appid, filename, meta), true
+}
+
+type GameSummary struct {
+	Count         int
+	Favorites     int
+	FirstCapture  time.Time
+	LatestCapture time.Time
+}
+
+// Summaries returns image counts and capture time ranges for every game.
+func (gi *GameImages) Summaries() map[string]GameSummary {
+	gi.lock.RLock()
+	defer gi.lock.RUnlock()
+
+	summaries := make(map[string]GameSummary)
+	for appid, game := range gi.Games {
+		hidden := sdrSiblings(game)
+		sum := GameSummary{Count: len(game) - len(hidden)}
+		for filename, meta := range game {
+			if _, ok := hidden[filename]; ok {
+				continue
+			}
+			if sum.FirstCapture.IsZero() || meta.CapturedAt.Before(sum.FirstCapture) {
+				sum.FirstCapture = meta.CapturedAt
+			}
+			if meta.CapturedAt.After(sum.LatestCapture) {
+				sum.LatestCapture = meta.CapturedAt
+			}
+			if meta.Favorite {
+				sum.Favorites++
+			}
+		}
+		summaries[appid] = sum
 	}
+	return summaries
+}
+
+// AllMetadata returns the metadata for every image of every game, oldest
+// first.
+func (gi *GameImages) AllMetadata() []Metadata {
+	gi.lock.RLock()
+	defer gi.lock.RUnlock()
+
+	images := []Metadata{}
+	for appid, game := range gi.Games {
+		hidden := sdrSiblings(game)
+		for filename, meta := range game {
+			if _, ok := hidden[filename]; !ok {
+				images = append(images, newMetadata(appid, filename, meta))
+			}
+		}
+	}
+
+	slices.SortFunc(images, compareMetadata)
+	return images
+}
 
-	slices.SortFunc(images, func(a, b Metadata) int {
-		return strings.Compare(a.Src, b.Src)
-	})
+// Favorites returns the starred images of a game, or of every game if appid
+// is empty, newest first.
+func (gi *GameImages) Favorites(appid string) []Metadata {
+	gi.lock.RLock()
+	defer gi.lock.RUnlock()
+
+	images := []Metadata{}
+	for id, game := range gi.Games {
+		if appid != "" && id != appid {
+			continue
+		}
 
+		hidden := sdrSiblings(game)
+		for filename, meta := range game {
+			if _, ok := hidden[filename]; meta.Favorite && !ok {
+				images = append(images, newMetadata(id, filename, meta))
+			}
+		}
+	}
+
+	slices.SortFunc(images, newestFirst)
 	return images
 }
 
+func newMetadata(appid, filename string, meta *ImageMeta) Metadata {
+	md := Metadata{
+		// FIXME: oh god why
+		Src:    fmt.Sprintf("/img/%s/%s", appid, filename),
+		Width:  meta.Width,
+		Height: meta.Height,
+		HDR:    meta.HDR,
+
+		AppId:      appid,
+		Filename:   filename,
+		Thumb:      fmt.Sprintf("/thumb/%s/%s", appid, filename),
+		CapturedAt: meta.CapturedAt,
+
+		Video:    meta.Video,
+		Duration: meta.Duration.Seconds(),
+
+		Caption:  meta.Caption,
+		Tags:     slices.Clone(meta.Tags),
+		Favorite: meta.Favorite,
+	}
+
+	if meta.Steam != nil {
+		md.Location = meta.Steam.Location
+		if meta.Steam.PublishedFileId != "" {
+			md.SteamUrl = "https://steamcommunity.com/sharedfiles/filedetails/?id=" + meta.Steam.PublishedFileId
+		}
+	}
+
+	if meta.Preview {
+		md.Original = md.Src
+		md.Src = fmt.Sprintf("/preview/%s/%s", appid, filename)
+	}
+	return md
+}
+
+// compareMetadata sorts oldest first.  Filenames break ties, which keeps
+// Steam's _1, _2, etc. counters in order.
+func compareMetadata(a, b Metadata) int {
+	if c := a.CapturedAt.Compare(b.CapturedAt); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.AppId, b.AppId); c != 0 {
+		return c
+	}
+	return compareFilenames(a.Filename, b.Filename)
+}
+
+// compareFilenames sorts Steam's screenshot counters numerically, so _10
+// comes after _2.  Other filenames are compared as they are.
+func compareFilenames(a, b string) int {
+	prefixA, counterA, okA := steamCounter(a)
+	prefixB, counterB, okB := steamCounter(b)
+	if okA && okB && prefixA == prefixB && counterA != counterB {
+		return cmp.Compare(counterA, counterB)
+	}
+	return strings.Compare(a, b)
+}
+
+// steamCounter splits "20240312183045_12.jpg" into "20240312183045" and 12.
+func steamCounter(filename string) (prefix string, counter int, ok bool) {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	idx := strings.LastIndexByte(base, '_')
+	if idx < 0 {
+		return "", 0, false
+	}
+
+	counter, err := strconv.Atoi(base[idx+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return base[:idx], counter, true
+}
+
+// Version changes whenever an image is added or removed.
+func (gi *GameImages) Version() uint64 {
+	gi.lock.RLock()
+	defer gi.lock.RUnlock()
+	return gi.version
+}
+
 // Number of games
 func (gi *GameImages) Length() int {
 	gi.lock.Lock()
@@ -300,10 +718,11 @@ func (gi *GameImages) Length() int {
 	return len(gi.Games)
 }
 
-// Number of images for a given AppId
+// Number of images for a given AppId, not counting the SDR copies of HDR
+// images.
 func (gi *GameImages) Count(appid string) int {
 	gi.lock.Lock()
 	defer gi.lock.Unlock()
 
-	return len(gi.Games[appid])
+	return len(gi.Games[appid]) - len(sdrSiblings(gi.Games[appid]))
 }
@@ -0,0 +1,177 @@
+package steamscreenshots
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadKeepsSteamData(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, ImageCacheFile)
+
+	gi, err := LoadImageCache(filename, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 3, 12, 18, 30, 45, 0, time.UTC)
+	steam := &SteamScreenshot{Caption: "The summit", Created: created}
+	if err = gi.addEntry("220", "a.jpg", &ImageMeta{}, steam); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadImageCache(filename, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := reloaded.Games["220"]["a.jpg"]
+	if meta == nil || meta.Steam == nil || meta.Caption != "The summit" || !meta.Steam.Created.Equal(created) {
+		t.Errorf("Steam data wasn't saved: %+v", meta)
+	}
+}
+
+func TestCompareFilenames(t *testing.T) {
+	names := []string{"20240312183045_10.jpg", "20240312183045_2.jpg", "20240312183045_1.jpg", "a.png"}
+	slices.SortFunc(names, compareFilenames)
+
+	expect := []string{"20240312183045_1.jpg", "20240312183045_2.jpg", "20240312183045_10.jpg", "a.png"}
+	if !slices.Equal(names, expect) {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestUploadFileMode(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{
+		settings:  Settings{ImageDirectory: dir, ApiKey: "key", ApiWhitelist: []string{"192.0.2.1"}},
+		newImages: make(chan NewImage, 1),
+	}
+
+	req := httptest.NewRequest("PUT", "/api/upload/220/a.jpg", strings.NewReader("image"))
+	req.SetPathValue("appid", "220")
+	req.SetPathValue("filename", "a.jpg")
+	req.Header.Set("api-key", "key")
+	w := httptest.NewRecorder()
+	s.handler_api_upload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", w.Code, w.Body)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "220", "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("uploaded file has mode %v", info.Mode().Perm())
+	}
+}
+
+func TestHdrSiblingHidden(t *testing.T) {
+	dir := t.TempDir()
+	gi, err := LoadImageCache(filepath.Join(dir, ImageCacheFile), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gi.Games["220"] = map[string]*ImageMeta{
+		"a.jxr": {HDR: true, Preview: true},
+		"a.png": {},
+		"b.png": {HDR: true, Preview: true},
+		"b.jpg": {},
+		"c.png": {},
+	}
+
+	names := []string{}
+	for _, md := range gi.GetMetadata("220") {
+		names = append(names, md.Filename)
+	}
+	if !slices.Equal(names, []string{"a.jxr", "b.png", "c.png"}) {
+		t.Errorf("gallery has %v", names)
+	}
+
+	// Starring a hidden copy stars its HDR image.
+	_, ok, err := gi.EditImage("220", "b.jpg", func(meta *ImageMeta) { meta.Favorite = true })
+	if !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+
+	if count := gi.Count("220"); count != 3 {
+		t.Errorf("expected 3 images, got %d", count)
+	}
+	if sum := gi.Summaries()["220"]; sum.Count != 3 || sum.Favorites != 1 {
+		t.Errorf("summary is %+v", sum)
+	}
+	if favs := gi.Favorites(""); len(favs) != 1 || favs[0].Filename != "b.png" {
+		t.Errorf("favorite wasn't moved to the HDR image: %v", favs)
+	}
+	if _, ok := gi.GetImage("220", "b.jpg"); !ok {
+		t.Error("sibling can't be found directly")
+	}
+}
+
+func TestEditImageSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	gi, err := LoadImageCache(filepath.Join(dir, "missing", ImageCacheFile), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gi.Games["220"] = map[string]*ImageMeta{"a.jpg": {Caption: "old"}}
+	s := &Server{
+		settings:   Settings{ApiKey: "key", ApiWhitelist: []string{"192.0.2.1"}},
+		ImageCache: gi,
+	}
+
+	req := httptest.NewRequest("PATCH", "/api/v1/images/220/a.jpg", strings.NewReader(`{"caption": "new"}`))
+	req.SetPathValue("appid", "220")
+	req.SetPathValue("filename", "a.jpg")
+	req.Header.Set("api-key", "key")
+	w := httptest.NewRecorder()
+	s.handler_api_v1_edit_image(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d: %s", w.Code, w.Body)
+	}
+
+	if md, _ := gi.GetImage("220", "a.jpg"); md.Caption != "old" {
+		t.Errorf("unsaved caption was kept: %q", md.Caption)
+	}
+}
+
+func TestScanSkipsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for _, name := range []string{"220/a.png", "220/c.png", "400/a.png"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = png.Encode(file, img)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "220", "b.png"), []byte("not a png"), 0644)
+
+	gi := NewGameImages()
+	gi.Root = dir
+	gi.Games["220"] = map[string]*ImageMeta{"b.png": {Caption: "kept"}}
+	if err := gi.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if gi.Count("220") != 3 || gi.Count("400") != 1 {
+		t.Errorf("files after a bad one weren't indexed: %v", gi.Games)
+	}
+	if md, _ := gi.GetImage("220", "b.png"); md.Caption != "kept" {
+		t.Error("the bad file's entry was lost")
+	}
+}
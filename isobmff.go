@@ -0,0 +1,195 @@
+package steamscreenshots
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Minimal reader for ISO base media file format boxes.  HEIF, AVIF and MP4
+// all use this container; only enough is implemented to pull dimensions and
+// durations out of the headers.
+
+// maxBmffBox limits the size of the boxes that are kept.  The headers that
+// are read are small; even a long recording's moov box is a few megabytes.
+const maxBmffBox = 64 << 20
+
+type bmffBox struct {
+	Type string
+	Data []byte // payload, without the box header
+}
+
+// readBmffBoxes reads the top level boxes from r.  Only the payloads of the
+// box types listed in want are kept in memory, everything else is skipped.
+func readBmffBoxes(r io.Reader, want ...string) ([]bmffBox, error) {
+	boxes := []bmffBox{}
+	hdr := make([]byte, 16)
+
+	for {
+		_, err := io.ReadFull(r, hdr[:8])
+		if err == io.EOF {
+			return boxes, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+		hdrLen := int64(8)
+
+		switch size {
+		case 0:
+			// box extends to the end of the file
+			size = -1
+		case 1:
+			if _, err = io.ReadFull(r, hdr[8:16]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			hdrLen = 16
+		}
+
+		if size != -1 && size < hdrLen {
+			return nil, fmt.Errorf("invalid size for %q box: %d", typ, size)
+		}
+
+		keep := false
+		for _, w := range want {
+			if w == typ {
+				keep = true
+				break
+			}
+		}
+
+		if !keep {
+			if size == -1 {
+				return boxes, nil
+			}
+			if err = skipBytes(r, size-hdrLen); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var data []byte
+		if size == -1 {
+			data, err = io.ReadAll(io.LimitReader(r, maxBmffBox+1))
+			if err == nil && len(data) > maxBmffBox {
+				err = fmt.Errorf("%q box is too large", typ)
+			}
+		} else if size-hdrLen > maxBmffBox {
+			err = fmt.Errorf("%q box is too large: %d bytes", typ, size)
+		} else {
+			data = make([]byte, size-hdrLen)
+			_, err = io.ReadFull(r, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, bmffBox{Type: typ, Data: data})
+	}
+}
+
+// bmffChildren splits a payload into its child boxes.
+func bmffChildren(data []byte) []bmffBox {
+	boxes := []bmffBox{}
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		typ := string(data[4:8])
+		hdrLen := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdrLen = 16
+		}
+
+		if size < hdrLen || size > uint64(len(data)) {
+			return boxes
+		}
+
+		boxes = append(boxes, bmffBox{Type: typ, Data: data[hdrLen:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// bmffFind walks down the box tree following path and returns every box
+// matching the last element.  Full boxes (those with a version and flags
+// field before their children) are listed in bmffFullBoxes.
+func bmffFind(boxes []bmffBox, path ...string) []bmffBox {
+	if len(path) == 0 {
+		return nil
+	}
+
+	found := []bmffBox{}
+	for _, b := range boxes {
+		if b.Type != path[0] {
+			continue
+		}
+
+		if len(path) == 1 {
+			found = append(found, b)
+			continue
+		}
+
+		data := b.Data
+		if bmffFullBoxes[b.Type] {
+			if len(data) < 4 {
+				continue
+			}
+			data = data[4:]
+		}
+		found = append(found, bmffFind(bmffChildren(data), path[1:]...)...)
+	}
+	return found
+}
+
+var bmffFullBoxes = map[string]bool{
+	"meta": true,
+}
+
+// heifConfig returns the dimensions of the largest image in a HEIF/AVIF
+// file.  Grid images and thumbnails each get their own "ispe" property, so
+// the biggest one is the full image.
+func heifConfig(r io.Reader) (image.Config, error) {
+	boxes, err := readBmffBoxes(r, "meta")
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	size := image.Config{}
+	for _, ispe := range bmffFind(boxes, "meta", "iprp", "ipco", "ispe") {
+		// version/flags, then width and height
+		if len(ispe.Data) < 12 {
+			continue
+		}
+		w := int(binary.BigEndian.Uint32(ispe.Data[4:8]))
+		h := int(binary.BigEndian.Uint32(ispe.Data[8:12]))
+		if w*h > size.Width*size.Height {
+			size.Width, size.Height = w, h
+		}
+	}
+
+	if size.Width == 0 || size.Height == 0 {
+		return size, fmt.Errorf("no image size found")
+	}
+	return size, nil
+}
+
+// skipBytes discards n bytes from r, seeking if possible.
+func skipBytes(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
@@ -0,0 +1,72 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func loadOpenAPI(t *testing.T) map[string]map[string]json.RawMessage {
+	raw, err := embeddedContent.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("openapi.json is invalid: %s", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("unexpected openapi version %q", doc.OpenAPI)
+	}
+	return doc.Paths
+}
+
+// specPath converts a ServeMux pattern to its method and OpenAPI path.
+// Patterns without a method accept GET.
+func specPath(pattern string) (string, string) {
+	method := "get"
+	if m, p, found := strings.Cut(pattern, " "); found {
+		method = strings.ToLower(m)
+		pattern = p
+	}
+	return method, strings.TrimSuffix(pattern, "{$}")
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	paths := loadOpenAPI(t)
+
+	s := &Server{}
+	for _, rt := range s.routes() {
+		method, path := specPath(rt.Pattern)
+		ops, ok := paths[path]
+		if !ok {
+			t.Errorf("route %q missing from openapi.json (expected path %q)", rt.Pattern, path)
+			continue
+		}
+		if _, ok := ops[method]; !ok {
+			t.Errorf("route %q missing %s operation in openapi.json", rt.Pattern, method)
+		}
+	}
+}
+
+func TestOpenAPINoStalePaths(t *testing.T) {
+	paths := loadOpenAPI(t)
+
+	registered := map[string]bool{}
+	s := &Server{}
+	for _, rt := range s.routes() {
+		_, path := specPath(rt.Pattern)
+		registered[path] = true
+	}
+
+	for path := range paths {
+		if !registered[path] {
+			t.Errorf("openapi.json documents %q, which isn't a registered route", path)
+		}
+	}
+}
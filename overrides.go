@@ -0,0 +1,143 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// OverridesFile holds the appid overrides.  They used to live in the settings
+// file; those are copied here the first time the server starts without one.
+const OverridesFile = "overrides.json"
+
+// OverrideList is the set of names that replace whatever Steam calls a game.
+// It's the "overrides" name resolver.
+type OverrideList struct {
+	names    map[string]string // appid to name
+	m        sync.Mutex
+	filename string
+}
+
+// LoadOverrides reads the overrides file.  If it doesn't exist the list
+// starts out with initial, which is saved.
+func LoadOverrides(filename string, initial []AppidOverride) (*OverrideList, error) {
+	ol := &OverrideList{
+		names:    make(map[string]string),
+		filename: filename,
+	}
+
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		if len(initial) == 0 {
+			return ol, nil
+		}
+
+		for _, ovr := range initial {
+			ol.names[ovr.Appid] = ovr.Name
+		}
+		fmt.Printf("Moving %d appid overrides from the settings file to %s\n", len(initial), filename)
+		return ol, ol.save()
+	} else if err != nil {
+		return nil, err
+	}
+
+	overrides := []AppidOverride{}
+	if err = json.Unmarshal(raw, &overrides); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", filename, err)
+	}
+
+	for _, ovr := range overrides {
+		ol.names[ovr.Appid] = ovr.Name
+	}
+	return ol, nil
+}
+
+// save writes the overrides to disk sorted by appid.  It expects the lock to
+// be held.
+func (ol *OverrideList) save() error {
+	if ol.filename == "" {
+		return nil
+	}
+
+	overrides := []AppidOverride{}
+	for appid, name := range ol.names {
+		overrides = append(overrides, AppidOverride{Appid: appid, Name: name})
+	}
+	slices.SortFunc(overrides, func(a, b AppidOverride) int {
+		return strings.Compare(a.Appid, b.Appid)
+	})
+
+	raw, err := json.MarshalIndent(overrides, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ol.filename, raw, 0644)
+}
+
+func (ol *OverrideList) ResolveName(appid string) (string, bool, error) {
+	ol.m.Lock()
+	defer ol.m.Unlock()
+
+	name, ok := ol.names[appid]
+	return name, ok, nil
+}
+
+// List returns every override sorted by name.
+func (ol *OverrideList) List() []AppidOverride {
+	ol.m.Lock()
+	defer ol.m.Unlock()
+
+	overrides := []AppidOverride{}
+	for appid, name := range ol.names {
+		overrides = append(overrides, AppidOverride{Appid: appid, Name: name})
+	}
+
+	slices.SortFunc(overrides, func(a, b AppidOverride) int {
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Appid, b.Appid)
+	})
+	return overrides
+}
+
+// Set adds or replaces an override.  created is true if there wasn't one for
+// the appid.  Nothing is changed if it can't be saved.
+func (ol *OverrideList) Set(appid, name string) (created bool, err error) {
+	ol.m.Lock()
+	defer ol.m.Unlock()
+
+	old, exists := ol.names[appid]
+	ol.names[appid] = name
+	if err = ol.save(); err != nil {
+		if exists {
+			ol.names[appid] = old
+		} else {
+			delete(ol.names, appid)
+		}
+		return false, err
+	}
+	return !exists, nil
+}
+
+// Delete removes an override.  ok is false if there wasn't one.  The override
+// is kept if the change can't be saved.
+func (ol *OverrideList) Delete(appid string) (ok bool, err error) {
+	ol.m.Lock()
+	defer ol.m.Unlock()
+
+	old, ok := ol.names[appid]
+	if !ok {
+		return false, nil
+	}
+
+	delete(ol.names, appid)
+	if err = ol.save(); err != nil {
+		ol.names[appid] = old
+	}
+	return true, err
+}
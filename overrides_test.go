@@ -0,0 +1,69 @@
+package steamscreenshots
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverrideList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), OverridesFile)
+
+	// Overrides from the settings file are moved over the first time.
+	ol, err := LoadOverrides(filename, []AppidOverride{{Appid: "70", Name: "Half-Life"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created, err := ol.Set("440", "TF2"); err != nil || !created {
+		t.Fatalf("Set returned %v, %v", created, err)
+	}
+	if created, _ := ol.Set("440", "Team Fortress 2"); created {
+		t.Error("replacing an override reported it as created")
+	}
+	if ok, err := ol.Delete("70"); err != nil || !ok {
+		t.Fatalf("Delete returned %v, %v", ok, err)
+	}
+	if ok, _ := ol.Delete("70"); ok {
+		t.Error("deleted a missing override")
+	}
+
+	// The settings file's overrides aren't used once the file exists.
+	reloaded, err := LoadOverrides(filename, []AppidOverride{{Appid: "70", Name: "Half-Life"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := reloaded.List()
+	if len(list) != 1 || list[0] != (AppidOverride{Appid: "440", Name: "Team Fortress 2"}) {
+		t.Errorf("unexpected overrides after reloading: %v", list)
+	}
+}
+
+func TestOverrideSaveFails(t *testing.T) {
+	games := newTestGameList(t)
+	s := newTestServer(t, newFakeSteam(t), games, Settings{
+		ApiKey:        "key",
+		ApiWhitelist:  []string{"192.0.2.1"},
+		NameResolvers: []string{ResolverOverrides, ResolverCache},
+	})
+	s.Overrides.filename = filepath.Join(t.TempDir(), "missing", OverridesFile)
+
+	req := httptest.NewRequest("PUT", "/api/v1/overrides/440", strings.NewReader(`{"name": "TF2"}`))
+	req.SetPathValue("appid", "440")
+	req.Header.Set("api-key", "key")
+	w := httptest.NewRecorder()
+	s.handler_api_v1_set_override(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+
+	if _, ok, _ := s.Overrides.ResolveName("440"); ok {
+		t.Error("unsaved override was kept")
+	}
+	if name, ok := games.Lookup("440"); ok {
+		t.Errorf("game list was updated to %q", name)
+	}
+}
@@ -0,0 +1,93 @@
+package steamscreenshots
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursors point at the last image of the previous page.  They're keyed on the
+// sort fields instead of an offset so pages don't shift around when new
+// screenshots are uploaded while someone is scrolling.
+
+const (
+	DefaultPageSize = 120
+	MaxPageSize     = 500
+)
+
+func encodeCursor(md Metadata) string {
+	raw := fmt.Sprintf("%d/%s/%s", md.CapturedAt.UnixNano(), md.AppId, md.Filename)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (Metadata, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), "/", 3)
+	if len(parts) != 3 {
+		return Metadata{}, fmt.Errorf("invalid cursor")
+	}
+
+	nano, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("invalid cursor")
+	}
+
+	return Metadata{
+		CapturedAt: time.Unix(0, nano),
+		AppId:      parts[1],
+		Filename:   parts[2],
+	}, nil
+}
+
+// paginate returns up to limit images following the cursor.  images must
+// already be sorted with cmp.  An empty cursor starts at the beginning.  The
+// returned cursor is empty on the last page.
+func paginate(images []Metadata, cmp func(a, b Metadata) int, cursor string, limit int) ([]Metadata, string, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	} else if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	start := 0
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		start = sort.Search(len(images), func(i int) bool {
+			return cmp(images[i], after) > 0
+		})
+	}
+
+	end := min(start+limit, len(images))
+	page := images[start:end]
+
+	next := ""
+	if end < len(images) {
+		next = encodeCursor(page[len(page)-1])
+	}
+	return page, next, nil
+}
+
+// pageLimit parses the limit query parameter.
+func pageLimit(val string) int {
+	limit, err := strconv.Atoi(val)
+	if err != nil {
+		return DefaultPageSize
+	}
+	return limit
+}
+
+// newestFirst reverses compareMetadata.
+func newestFirst(a, b Metadata) int {
+	return compareMetadata(b, a)
+}
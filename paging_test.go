@@ -0,0 +1,96 @@
+package steamscreenshots
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPaginateTies(t *testing.T) {
+	now := time.Now()
+	images := []Metadata{}
+	for _, appid := range []string{"70", "220", "440"} {
+		for _, filename := range []string{"20240312183045_1.jpg", "20240312183045_2.jpg", "20240312183045_10.jpg"} {
+			images = append(images, Metadata{AppId: appid, Filename: filename, CapturedAt: now})
+		}
+	}
+	images = append(images, Metadata{AppId: "70", Filename: "old.jpg", CapturedAt: now.Add(-time.Hour)})
+
+	for _, cmp := range []func(a, b Metadata) int{compareMetadata, newestFirst} {
+		slices.SortFunc(images, cmp)
+
+		for limit := 1; limit <= len(images); limit++ {
+			seen := []Metadata{}
+			cursor := ""
+			for {
+				page, next, err := paginate(images, cmp, cursor, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page) == 0 || len(page) > limit {
+					t.Fatalf("limit %d: page of %d", limit, len(page))
+				}
+
+				seen = append(seen, page...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			if !slices.EqualFunc(seen, images, func(a, b Metadata) bool { return cmp(a, b) == 0 }) {
+				t.Errorf("limit %d: pages don't match the list:\n%v\n%v", limit, seen, images)
+			}
+		}
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	s := newTestServer(t, newFakeSteam(t), newTestGameList(t), Settings{NameResolvers: []string{ResolverCache}})
+	s.ImageCache = NewGameImages()
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{
+		"a.jpg": {CapturedAt: time.Now()},
+		"b.jpg": {CapturedAt: time.Now()},
+	}
+
+	get := func(handler http.HandlerFunc, target, cursor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target+"?limit=1&cursor="+url.QueryEscape(cursor), nil)
+		req.SetPathValue("appid", "220")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	handlers := map[string]http.HandlerFunc{
+		"/api/timeline":        s.handler_api_timeline,
+		"/api/game/220/images": s.handler_api_game_images,
+		"/api/favorites":       s.handler_api_favorites,
+	}
+	for target, handler := range handlers {
+		for _, cursor := range []string{
+			"!!!not base64",
+			base64.RawURLEncoding.EncodeToString([]byte("garbage")),
+			base64.RawURLEncoding.EncodeToString([]byte("yesterday/220/a.jpg")),
+			base64.StdEncoding.EncodeToString([]byte("1/220/a.jpg")),
+		} {
+			if w := get(handler, target, cursor); w.Code != http.StatusBadRequest {
+				t.Errorf("%s with cursor %q: expected 400, got %d", target, cursor, w.Code)
+			}
+		}
+	}
+
+	// The cursor from one page fetches the next.
+	w := get(s.handler_api_game_images, "/api/game/220/images", "")
+	page := ImagePage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || page.Next == "" {
+		t.Fatalf("unexpected first page: %s", w.Body)
+	}
+	if w = get(s.handler_api_game_images, "/api/game/220/images", page.Next); w.Code != 200 {
+		t.Errorf("valid cursor rejected: %d %s", w.Code, w.Body)
+	}
+}
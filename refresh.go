@@ -0,0 +1,162 @@
+package steamscreenshots
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Resolvers that talk to Steam are never used while rendering a page.  When
+// the local resolvers don't know a name, the appid is shown and the name is
+// looked up in the background for the next request.  Steam's app list is
+// also refreshed in the background every GameListTTL minutes, along with the
+// store details of games that don't have any yet.
+
+const (
+	// DefaultGameListTTL is the number of minutes between app list refreshes.
+	DefaultGameListTTL = 24 * 60
+
+	// storeRequestInterval spaces out requests to the store API, which only
+	// allows a couple hundred every five minutes.
+	storeRequestInterval = 1500 * time.Millisecond
+)
+
+// remoteResolver is implemented by resolvers that make network requests.
+type remoteResolver interface {
+	NameResolver
+	remote()
+}
+
+func (*StoreResolver) remote()   {}
+func (*AppListResolver) remote() {}
+
+// refresher is implemented by resolvers that keep a list up to date.
+type refresher interface {
+	Refresh() error
+}
+
+// nameLookups runs at most one background lookup per appid at a time, no
+// matter how many requests ask for it.
+type nameLookups struct {
+	calls map[string]bool
+	m     sync.Mutex
+	wg    sync.WaitGroup
+}
+
+// Go runs lookup for appid in the background unless it's already running.
+func (l *nameLookups) Go(appid string, lookup func()) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.calls[appid] {
+		return
+	}
+	if l.calls == nil {
+		l.calls = make(map[string]bool)
+	}
+	l.calls[appid] = true
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		lookup()
+
+		l.m.Lock()
+		delete(l.calls, appid)
+		l.m.Unlock()
+	}()
+}
+
+// Wait blocks until every running lookup has finished.
+func (l *nameLookups) Wait() {
+	l.wg.Wait()
+}
+
+// lookupName asks the remote resolvers about appid.  Names that are found
+// are added to the game list.
+func (s *Server) lookupName(appid string) {
+	for _, r := range s.remoteResolvers {
+		name, ok, err := r.ResolveName(appid)
+		if err != nil {
+			fmt.Printf("Error resolving name for %s: %s\n", appid, err)
+			continue
+		}
+
+		if ok {
+			if cached, _ := s.Games.Lookup(appid); cached != name {
+				s.Games.Set(appid, name)
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) gameListTTL() time.Duration {
+	if s.settings.GameListTTL <= 0 {
+		return DefaultGameListTTL * time.Minute
+	}
+	return time.Duration(s.settings.GameListTTL) * time.Minute
+}
+
+// refreshGameList refreshes the lists of the remote resolvers every TTL.  The
+// first refresh is scheduled from when games.cache was last written, so
+// restarting the server doesn't download everything again.
+func (s *Server) refreshGameList() {
+	ttl := s.gameListTTL()
+
+	wait := time.Duration(0)
+	if info, err := os.Stat(s.Games.filename); err == nil && s.Games.Length() > 0 {
+		wait = max(ttl-time.Since(info.ModTime()), 0)
+	}
+
+	for {
+		time.Sleep(wait)
+		wait = ttl
+
+		for _, r := range s.remoteResolvers {
+			if ref, ok := r.(refresher); ok {
+				if err := ref.Refresh(); err != nil {
+					fmt.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// refreshGameDetails asks the store about games that don't have any details,
+// at startup and then every TTL.  It does nothing if the store resolver isn't
+// enabled.
+func (s *Server) refreshGameDetails() {
+	var store *StoreResolver
+	for _, r := range s.remoteResolvers {
+		if sr, ok := r.(*StoreResolver); ok {
+			store = sr
+		}
+	}
+	if store == nil {
+		return
+	}
+
+	for {
+		s.fetchGameDetails(store, storeRequestInterval)
+		time.Sleep(s.gameListTTL())
+	}
+}
+
+func (s *Server) fetchGameDetails(store *StoreResolver, pause time.Duration) {
+	for _, appid := range s.ImageCache.GetGames() {
+		if _, err := strconv.ParseUint(appid, 10, 32); err != nil {
+			continue
+		}
+		if info, _ := s.Games.Info(appid); info.HasDetails() {
+			continue
+		}
+
+		if _, _, err := store.Details(appid); err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(pause)
+	}
+}
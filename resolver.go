@@ -0,0 +1,342 @@
+package steamscreenshots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NameResolver looks up the display name of a game.  ok is false when the
+// resolver doesn't know the appid, in which case the next resolver in the
+// chain is asked.
+type NameResolver interface {
+	ResolveName(appid string) (name string, ok bool, err error)
+}
+
+// Resolver names used in Settings.NameResolvers.
+const (
+	ResolverOverrides = "overrides" // overrides.json, see OverrideList
+	ResolverMapping   = "mapping"   // Settings.NameMappingFile
+	ResolverCache     = "cache"     // games.cache
+	ResolverNonSteam  = "nonsteam"  // generated name for shortcut appids
+	ResolverStore     = "store"     // Steam store API, one app at a time
+	ResolverAppList   = "applist"   // Steam's complete app list
+)
+
+var DefaultNameResolvers = []string{
+	ResolverOverrides,
+	ResolverMapping,
+	ResolverCache,
+	ResolverNonSteam,
+	ResolverStore,
+	ResolverAppList,
+}
+
+const (
+	DefaultStoreUrl    = "https://store.steampowered.com"
+	DefaultSteamApiUrl = "https://api.steampowered.com"
+
+	// How long to wait before asking Steam about an appid again after it
+	// didn't know it, and between downloads of the full app list.
+	resolverRetryInterval = 30 * time.Minute
+)
+
+// newResolvers builds the resolver chain from the settings.  Resolvers that
+// use the network are returned separately; see refresh.go.
+func (s *Server) newResolvers() (local []NameResolver, remote []NameResolver, err error) {
+	names := s.settings.NameResolvers
+	if len(names) == 0 {
+		names = DefaultNameResolvers
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resolvers := []NameResolver{}
+	for _, name := range names {
+		switch name {
+		case ResolverOverrides:
+			resolvers = append(resolvers, s.Overrides)
+		case ResolverMapping:
+			if s.settings.NameMappingFile != "" {
+				resolvers = append(resolvers, &MappingFileResolver{Filename: s.settings.NameMappingFile})
+			}
+		case ResolverCache:
+			resolvers = append(resolvers, &CacheResolver{Games: s.Games})
+		case ResolverNonSteam:
+			resolvers = append(resolvers, NonSteamResolver{})
+		case ResolverStore:
+			resolvers = append(resolvers, &StoreResolver{BaseUrl: DefaultStoreUrl, Client: client, Games: s.Games})
+		case ResolverAppList:
+			resolvers = append(resolvers, &AppListResolver{BaseUrl: DefaultSteamApiUrl, Client: client, Games: s.Games})
+		default:
+			return nil, nil, fmt.Errorf("unknown name resolver %q", name)
+		}
+	}
+
+	for _, r := range resolvers {
+		if _, ok := r.(remoteResolver); ok {
+			remote = append(remote, r)
+		} else {
+			local = append(local, r)
+		}
+	}
+	return local, remote, nil
+}
+
+// MappingFileResolver reads names from a JSON object of appids to names
+// maintained by the user.  The file is reloaded when it changes.
+type MappingFileResolver struct {
+	Filename string
+
+	names   GameIDs
+	modTime time.Time
+	m       sync.Mutex
+}
+
+func (r *MappingFileResolver) ResolveName(appid string) (string, bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	info, err := os.Stat(r.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	if r.names == nil || !info.ModTime().Equal(r.modTime) {
+		raw, err := os.ReadFile(r.Filename)
+		if err != nil {
+			return "", false, err
+		}
+
+		names := GameIDs{}
+		if err = json.Unmarshal(raw, &names); err != nil {
+			return "", false, fmt.Errorf("invalid mapping file %s: %w", r.Filename, err)
+		}
+		r.names = names
+		r.modTime = info.ModTime()
+	}
+
+	name, ok := r.names[appid]
+	return name, ok, nil
+}
+
+// CacheResolver uses names that were previously looked up.
+type CacheResolver struct {
+	Games *GameList
+}
+
+func (r *CacheResolver) ResolveName(appid string) (string, bool, error) {
+	name, ok := r.Games.Lookup(appid)
+	return name, ok, nil
+}
+
+// NonSteamResolver names shortcuts to non-Steam games.  Their appids are
+// generated from a CRC and are longer than any real Steam appid.
+type NonSteamResolver struct{}
+
+func (NonSteamResolver) ResolveName(appid string) (string, bool, error) {
+	if isNonSteam(appid) {
+		return fmt.Sprintf("Non-Steam game (%s)", appid), true, nil
+	}
+	return "", false, nil
+}
+
+func isNonSteam(appid string) bool {
+	return len(appid) > 18
+}
+
+// StoreResolver asks the store API about a single app.  Names that are
+// found are added to the game list along with the rest of the app's details.
+type StoreResolver struct {
+	BaseUrl string
+	Client  *http.Client
+	Games   *GameList
+
+	misses map[string]time.Time // appids the store didn't know
+	m      sync.Mutex
+}
+
+type storeAppDetails map[string]storeApp
+
+type storeApp struct {
+	Success *bool `json:"success"` // nil if this isn't an appdetails response
+	Data    struct {
+		Type         string   `json:"type"`
+		Name         string   `json:"name"`
+		Developers   []string `json:"developers"`
+		HeaderImage  string   `json:"header_image"`
+		CapsuleImage string   `json:"capsule_image"`
+		Genres       []struct {
+			Description string `json:"description"`
+		} `json:"genres"`
+		ReleaseDate struct {
+			Date string `json:"date"`
+		} `json:"release_date"`
+	} `json:"data"`
+}
+
+// The format of release dates depends on the language of the request, but
+// they all have the year in them.
+var re_releaseYear = regexp.MustCompile(`\b(1[89]|20)\d\d\b`)
+
+func (app storeApp) gameInfo() GameInfo {
+	info := GameInfo{
+		Name:       app.Data.Name,
+		Type:       app.Data.Type,
+		Developers: app.Data.Developers,
+		HeaderUrl:  app.Data.HeaderImage,
+		CapsuleUrl: app.Data.CapsuleImage,
+	}
+
+	for _, g := range app.Data.Genres {
+		if g.Description != "" {
+			info.Genres = append(info.Genres, g.Description)
+		}
+	}
+
+	if year := re_releaseYear.FindString(app.Data.ReleaseDate.Date); year != "" {
+		info.ReleaseYear, _ = strconv.Atoi(year)
+	}
+	return info
+}
+
+func (r *StoreResolver) ResolveName(appid string) (string, bool, error) {
+	info, ok, err := r.Details(appid)
+	return info.Name, ok, err
+}
+
+// Details looks up everything the store has to say about appid and saves it
+// in the game list.
+func (r *StoreResolver) Details(appid string) (GameInfo, bool, error) {
+	if _, err := strconv.ParseUint(appid, 10, 32); err != nil {
+		return GameInfo{}, false, nil
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if last, ok := r.misses[appid]; ok && time.Since(last) < resolverRetryInterval {
+		return GameInfo{}, false, nil
+	}
+
+	query := url.Values{"appids": {appid}, "filters": {"basic,genres,release_date"}}
+	resp, err := r.Client.Get(r.BaseUrl + "/api/appdetails?" + query.Encode())
+	if err != nil {
+		return GameInfo{}, false, fmt.Errorf("store lookup for %s failed: %w", appid, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return GameInfo{}, false, fmt.Errorf("store lookup for %s failed: %s", appid, resp.Status)
+	}
+
+	details := storeAppDetails{}
+	if err = json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return GameInfo{}, false, fmt.Errorf("invalid store response for %s: %w", appid, err)
+	}
+
+	app := details[appid]
+	if app.Success == nil || !*app.Success || app.Data.Name == "" {
+		if r.misses == nil {
+			r.misses = make(map[string]time.Time)
+		}
+		r.misses[appid] = time.Now()
+		return GameInfo{}, false, nil
+	}
+
+	info := app.gameInfo()
+	r.Games.SetInfo(appid, info)
+	if err = r.Games.Save(); err != nil {
+		fmt.Println("unable to save game list:", err)
+	}
+	return info, true, nil
+}
+
+// AppListResolver downloads Steam's list of every app, which is tens of
+// megabytes.  It's refreshed on a schedule, and when an appid isn't in it at
+// most once per resolverRetryInterval.  Everything in it is added to the game
+// list.
+type AppListResolver struct {
+	BaseUrl string
+	Client  *http.Client
+	Games   *GameList
+
+	lastUpdate time.Time
+	m          sync.Mutex
+}
+
+// Structure of json from steam's servers
+type steamapps struct {
+	Applist struct {
+		Apps []struct {
+			Appid uint64 `json:"appid"`
+			Name  string `json:"name"`
+		} `json:"apps"`
+	} `json:"applist"`
+}
+
+func (r *AppListResolver) ResolveName(appid string) (string, bool, error) {
+	if name, ok := r.Games.Lookup(appid); ok {
+		return name, true, nil
+	}
+
+	r.m.Lock()
+	recent := !r.lastUpdate.IsZero() && time.Since(r.lastUpdate) < resolverRetryInterval
+	r.m.Unlock()
+
+	if !recent {
+		if err := r.Refresh(); err != nil {
+			return "", false, err
+		}
+	}
+
+	name, ok := r.Games.Lookup(appid)
+	return name, ok, nil
+}
+
+// Refresh downloads the app list.
+func (r *AppListResolver) Refresh() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.lastUpdate = time.Now()
+
+	fmt.Println("Updating games list")
+	resp, err := r.Client.Get(r.BaseUrl + "/ISteamApps/GetAppList/v2")
+	if err != nil {
+		return fmt.Errorf("Unable to get appid list from steam: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to get appid list from steam: %s", resp.Status)
+	}
+
+	alist := &steamapps{}
+	if err := json.NewDecoder(resp.Body).Decode(alist); err != nil {
+		return fmt.Errorf("Unable to unmarshal json: %w", err)
+	}
+
+	list := GameIDs{}
+	for _, a := range alist.Applist.Apps {
+		if a.Name != "" {
+			list[strconv.FormatUint(a.Appid, 10)] = a.Name
+		}
+	}
+	r.Games.Update(list)
+
+	if err := r.Games.Save(); err != nil {
+		return fmt.Errorf("Unable to save games.cache: %w", err)
+	}
+
+	fmt.Printf("Finished updating games list.  Appids: %d\n", len(list))
+	return nil
+}
@@ -0,0 +1,247 @@
+package steamscreenshots
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSteam stands in for the store and web APIs.
+type fakeSteam struct {
+	server       *httptest.Server
+	storeCalls   atomic.Int32
+	appListCalls atomic.Int32
+
+	release chan struct{} // if set, store requests wait for it to be closed
+}
+
+func newFakeSteam(t *testing.T) *fakeSteam {
+	f := &fakeSteam{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/appdetails", func(w http.ResponseWriter, r *http.Request) {
+		f.storeCalls.Add(1)
+		if f.release != nil {
+			<-f.release
+		}
+		appid := r.URL.Query().Get("appids")
+		if appid == "440" {
+			fmt.Fprintf(w, `{"440":{"success":true,"data":{"type":"game","name":"Team Fortress 2",`+
+				`"developers":["Valve"],"genres":[{"id":"1","description":"Action"},{"id":"37","description":"Free to Play"}],`+
+				`"release_date":{"coming_soon":false,"date":"10 Oct, 2007"}}}}`)
+			return
+		}
+		fmt.Fprintf(w, `{%q:{"success":false}}`, appid)
+	})
+	mux.HandleFunc("GET /ISteamApps/GetAppList/v2", func(w http.ResponseWriter, r *http.Request) {
+		f.appListCalls.Add(1)
+		fmt.Fprint(w, `{"applist":{"apps":[{"appid":70,"name":"Half-Life"},{"appid":80,"name":""}]}}`)
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func newTestGameList(t *testing.T) *GameList {
+	games, err := LoadGameList(filepath.Join(t.TempDir(), GameCacheFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return games
+}
+
+func resolve(t *testing.T, r NameResolver, appid string) (string, bool) {
+	t.Helper()
+	name, ok, err := r.ResolveName(appid)
+	if err != nil {
+		t.Fatalf("ResolveName(%q): %s", appid, err)
+	}
+	return name, ok
+}
+
+func TestStoreResolver(t *testing.T) {
+	steam := newFakeSteam(t)
+	games := newTestGameList(t)
+	r := &StoreResolver{BaseUrl: steam.server.URL, Client: steam.server.Client(), Games: games}
+
+	if name, ok := resolve(t, r, "440"); !ok || name != "Team Fortress 2" {
+		t.Fatalf("got %q, %v", name, ok)
+	}
+	if name, _ := games.Lookup("440"); name != "Team Fortress 2" {
+		t.Errorf("name wasn't added to the game list")
+	}
+	if info, _ := games.Info("440"); info.Type != "game" || info.ReleaseYear != 2007 || len(info.Genres) != 2 || info.Developers[0] != "Valve" {
+		t.Errorf("unexpected details: %+v", info)
+	}
+
+	// Misses aren't asked about again right away.
+	for i := 0; i < 2; i++ {
+		if _, ok := resolve(t, r, "12345"); ok {
+			t.Fatal("unknown appid resolved")
+		}
+	}
+	if calls := steam.storeCalls.Load(); calls != 2 {
+		t.Errorf("expected 2 store calls, got %d", calls)
+	}
+
+	// Non-Steam appids never reach the store.
+	if _, ok := resolve(t, r, "12345678901234567890"); ok {
+		t.Error("non-Steam appid resolved")
+	}
+	if calls := steam.storeCalls.Load(); calls != 2 {
+		t.Errorf("expected 2 store calls, got %d", calls)
+	}
+}
+
+func TestAppListResolver(t *testing.T) {
+	steam := newFakeSteam(t)
+	games := newTestGameList(t)
+	r := &AppListResolver{BaseUrl: steam.server.URL, Client: steam.server.Client(), Games: games}
+
+	if name, ok := resolve(t, r, "70"); !ok || name != "Half-Life" {
+		t.Fatalf("got %q, %v", name, ok)
+	}
+	if _, ok := resolve(t, r, "80"); ok {
+		t.Error("app without a name resolved")
+	}
+	if _, ok := resolve(t, r, "90"); ok {
+		t.Error("unknown appid resolved")
+	}
+
+	if calls := steam.appListCalls.Load(); calls != 1 {
+		t.Errorf("expected the app list to be downloaded once, got %d", calls)
+	}
+
+	reloaded, err := LoadGameList(games.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := reloaded.Lookup("70"); name != "Half-Life" {
+		t.Error("game list wasn't saved")
+	}
+}
+
+func TestMappingFileResolver(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "names.json")
+	r := &MappingFileResolver{Filename: filename}
+
+	if _, ok := resolve(t, r, "70"); ok {
+		t.Fatal("resolved without a mapping file")
+	}
+
+	if err := os.WriteFile(filename, []byte(`{"70": "Half-Life"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := resolve(t, r, "70"); !ok || name != "Half-Life" {
+		t.Fatalf("got %q, %v", name, ok)
+	}
+
+	if err := os.WriteFile(filename, []byte(`{"70": "Half-Life: Source"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := resolve(t, r, "70"); name != "Half-Life: Source" {
+		t.Errorf("mapping file wasn't reloaded, got %q", name)
+	}
+}
+
+// newTestServer builds a server using the default resolvers with the network
+// ones pointed at the fake.
+func newTestServer(t *testing.T, steam *fakeSteam, games *GameList, settings Settings) *Server {
+	s := &Server{Games: games, settings: settings}
+
+	var err error
+	s.Overrides, err = LoadOverrides(filepath.Join(t.TempDir(), OverridesFile), settings.AppidOverrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Aliases, err = LoadAliases(filepath.Join(t.TempDir(), AliasesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.resolvers, s.remoteResolvers, err = s.newResolvers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range s.remoteResolvers {
+		switch r := r.(type) {
+		case *StoreResolver:
+			r.BaseUrl, r.Client = steam.server.URL, steam.server.Client()
+		case *AppListResolver:
+			r.BaseUrl, r.Client = steam.server.URL, steam.server.Client()
+		}
+	}
+	return s
+}
+
+func TestResolverChain(t *testing.T) {
+	steam := newFakeSteam(t)
+	games := newTestGameList(t)
+	games.Set("440", "cached name")
+
+	s := newTestServer(t, steam, games, Settings{
+		AppidOverrides: []AppidOverride{{Appid: "70", Name: "Override"}},
+	})
+
+	tests := map[string]string{
+		"70":                   "Override",
+		"440":                  "cached name",
+		"12345678901234567890": "Non-Steam game (12345678901234567890)",
+		"90":                   "90",
+		".stfolder":            ".stfolder",
+	}
+	for appid, expected := range tests {
+		if name, _ := s.getGameName(appid); name != expected {
+			t.Errorf("getGameName(%q) = %q, expected %q", appid, name, expected)
+		}
+	}
+
+	// Only the unknown appid is looked up, after the page is done.
+	s.lookups.Wait()
+	if calls := steam.storeCalls.Load(); calls != 1 {
+		t.Errorf("expected 1 store call, got %d", calls)
+	}
+	if calls := steam.appListCalls.Load(); calls != 1 {
+		t.Errorf("expected 1 app list download, got %d", calls)
+	}
+
+	s.settings.NameResolvers = []string{"bogus"}
+	if _, _, err := s.newResolvers(); err == nil {
+		t.Error("expected an error for an unknown resolver")
+	}
+}
+
+func TestBackgroundLookup(t *testing.T) {
+	steam := newFakeSteam(t)
+	steam.release = make(chan struct{})
+	s := newTestServer(t, steam, newTestGameList(t), Settings{})
+
+	// Pages don't wait for Steam, and asking again while the lookup is
+	// running doesn't start another one.
+	for i := 0; i < 5; i++ {
+		if name, _ := s.getGameName("440"); name != "440" {
+			t.Fatalf("expected the appid while looking up, got %q", name)
+		}
+	}
+
+	close(steam.release)
+	s.lookups.Wait()
+
+	if calls := steam.storeCalls.Load(); calls != 1 {
+		t.Errorf("expected 1 store call, got %d", calls)
+	}
+	if name, _ := s.getGameName("440"); name != "Team Fortress 2" {
+		t.Errorf("expected the name after the lookup, got %q", name)
+	}
+}
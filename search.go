@@ -0,0 +1,219 @@
+package steamscreenshots
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// In-process search over game names and images.  The index is rebuilt lazily
+// on the first search after the image cache or game list changes; it's small
+// enough that rebuilding it is cheaper than keeping it updated piecemeal.
+
+// Weight of a match in each field.  Exact term matches count double.
+const (
+	weightFilename = 1
+	weightGameName = 2 // game name on an image result
+	weightTag      = 3
+	weightCaption  = 3
+	weightTitle    = 4 // game name on a game result
+)
+
+type searchDoc struct {
+	AppId    string
+	Filename string // empty for game results
+}
+
+type posting struct {
+	doc    int
+	weight int
+}
+
+type searchIndex struct {
+	docs     []searchDoc
+	terms    []string // sorted, for prefix lookups
+	postings map[string][]posting
+
+	imageVersion uint64
+	nameVersion  uint64
+	built        bool
+
+	lock sync.Mutex
+}
+
+type SearchResults struct {
+	Games  []string   // appids, best match first
+	Images []Metadata // best match first
+}
+
+// searchTerms splits text into lowercase words.  Anything that isn't a letter
+// or digit is a separator, so "Half-Life 2" becomes "half", "life" and "2".
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes text for the most recently added document.
+func (idx *searchIndex) add(weight int, text string) {
+	id := len(idx.docs) - 1
+	for _, term := range searchTerms(text) {
+		list := idx.postings[term]
+		// Keep only the best weight for a term in a document.
+		if n := len(list); n > 0 && list[n-1].doc == id {
+			list[n-1].weight = max(list[n-1].weight, weight)
+			continue
+		}
+		idx.postings[term] = append(list, posting{doc: id, weight: weight})
+	}
+}
+
+func (idx *searchIndex) build(images *GameImages, names *GameList) {
+	idx.docs = []searchDoc{}
+	idx.postings = make(map[string][]posting)
+
+	images.lock.RLock()
+	defer images.lock.RUnlock()
+
+	idx.imageVersion = images.version
+	idx.nameVersion = names.Version()
+
+	for appid, game := range images.Games {
+		name := names.Get(appid)
+
+		idx.docs = append(idx.docs, searchDoc{AppId: appid})
+		idx.add(weightTitle, name)
+
+		// Like galleries, leave out the SDR copies of HDR images.
+		hidden := sdrSiblings(game)
+		for filename, meta := range game {
+			if _, ok := hidden[filename]; ok {
+				continue
+			}
+
+			idx.docs = append(idx.docs, searchDoc{AppId: appid, Filename: filename})
+			idx.add(weightFilename, filename)
+			idx.add(weightGameName, name)
+			idx.add(weightCaption, meta.Caption)
+			for _, tag := range meta.Tags {
+				idx.add(weightTag, tag)
+			}
+		}
+	}
+
+	idx.terms = make([]string, 0, len(idx.postings))
+	for term := range idx.postings {
+		idx.terms = append(idx.terms, term)
+	}
+	sort.Strings(idx.terms)
+	idx.built = true
+}
+
+// match returns the score of every document matching a single query term.
+// Terms match as prefixes of indexed words.
+func (idx *searchIndex) match(term string) map[int]int {
+	scores := make(map[int]int)
+
+	start := sort.SearchStrings(idx.terms, term)
+	for _, t := range idx.terms[start:] {
+		if !strings.HasPrefix(t, term) {
+			break
+		}
+
+		mult := 1
+		if t == term {
+			mult = 2
+		}
+
+		for _, p := range idx.postings[t] {
+			scores[p.doc] = max(scores[p.doc], p.weight*mult)
+		}
+	}
+	return scores
+}
+
+// Search returns the games and images matching every word in query.  Each
+// list is truncated to limit entries.
+func (s *Server) Search(query string, limit int) SearchResults {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	idx := s.search
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if !idx.built || idx.imageVersion != s.ImageCache.Version() || idx.nameVersion != s.Games.Version() {
+		idx.build(s.ImageCache, s.Games)
+	}
+
+	results := SearchResults{Games: []string{}, Images: []Metadata{}}
+
+	var scores map[int]int
+	for _, term := range searchTerms(query) {
+		matched := idx.match(term)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+
+		for doc, score := range scores {
+			if m, ok := matched[doc]; ok {
+				scores[doc] = score + m
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	type hit struct {
+		doc   int
+		score int
+		md    Metadata
+	}
+
+	games := []hit{}
+	images := []hit{}
+	for doc, score := range scores {
+		d := idx.docs[doc]
+		if d.Filename == "" {
+			games = append(games, hit{doc: doc, score: score})
+			continue
+		}
+
+		md, ok := s.ImageCache.GetImage(d.AppId, d.Filename)
+		if !ok {
+			continue
+		}
+		md.Game = s.Games.Get(d.AppId)
+		images = append(images, hit{doc: doc, score: score, md: md})
+	}
+
+	slices.SortFunc(games, func(a, b hit) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		return strings.Compare(
+			strings.ToLower(s.Games.Get(idx.docs[a.doc].AppId)),
+			strings.ToLower(s.Games.Get(idx.docs[b.doc].AppId)),
+		)
+	})
+
+	// Newest first among equally good matches.
+	slices.SortFunc(images, func(a, b hit) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		return newestFirst(a.md, b.md)
+	})
+
+	for _, h := range games[:min(limit, len(games))] {
+		results.Games = append(results.Games, idx.docs[h.doc].AppId)
+	}
+	for _, h := range images[:min(limit, len(images))] {
+		results.Images = append(results.Images, h.md)
+	}
+	return results
+}
@@ -0,0 +1,24 @@
+package steamscreenshots
+
+import (
+	"testing"
+)
+
+func TestSearchHidesHdrSiblings(t *testing.T) {
+	s := &Server{Games: newTestGameList(t), ImageCache: NewGameImages(), search: &searchIndex{}}
+	s.ImageCache.Games["220"] = map[string]*ImageMeta{
+		"summit.jxr": {HDR: true, Preview: true, Caption: "summit"},
+		"summit.jpg": {Caption: "summit"},
+		"valley.jpg": {Caption: "summit valley"},
+	}
+
+	results := s.Search("summit", 10)
+	if len(results.Images) != 2 {
+		t.Fatalf("expected 2 images, got %v", results.Images)
+	}
+	for _, md := range results.Images {
+		if md.Filename == "summit.jpg" {
+			t.Errorf("SDR copy was found: %v", results.Images)
+		}
+	}
+}
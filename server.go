@@ -14,48 +14,56 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/fs"
 	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// Files kept in the server's working directory.
+const (
+	ImageCacheFile  = "image.cache"
+	GameCacheFile   = "games.cache"
+	AlbumsFile      = "albums.json"
+	BannerDirectory = "banners"
+)
+
 type Settings struct {
 	ImageDirectory  string
 	Address         string
-	AppidOverrides  []struct {
-		Appid string `json:"id"`
-		Name  string `json:"name"`
-	}
+	AppidOverrides  []AppidOverride // Moved to overrides.json on startup if it doesn't exist, then removed.
 	ApiKey          string // This will be regenerated if it is empty.
 	ApiWhitelist    []string
+
+	GalleryPageSize int // Images per page on a game's gallery.  Defaults to DefaultPageSize.
+
+	// Order to look up game names in.  See DefaultNameResolvers.
+	NameResolvers   []string
+	NameMappingFile string // JSON object of appids to names
+	GameListTTL     int    // Minutes between downloads of Steam's app list.  Defaults to DefaultGameListTTL.
+
+	BannerUrl    string // Base URL of game banners.  Defaults to DefaultBannerUrl.
+	BannerMaxAge int    // Days before a banner is downloaded again.  Defaults to DefaultBannerMaxAge.
 }
 
-var re_gamename = regexp.MustCompile(`<td itemprop="name">(.+?)</td>`)
+type AppidOverride struct {
+	Appid string `json:"id"`
+	Name  string `json:"name"`
+}
 
 var (
 	gitCommit string
 	version   string
 )
 
-// Structure of json from steam's servers
-type steamapps struct {
-	Applist struct {
-		Apps []struct {
-			Appid uint64 `json:"appid"`
-			Name  string `json:"name"`
-		} `json:"apps"`
-	} `json:"applist"`
-}
-
 type NewImage struct {
 	AppId string
 	Filename string
+	Steam *SteamScreenshot // nil if the uploader didn't send anything
 }
 
 type Server struct {
@@ -63,17 +71,24 @@ type Server struct {
 	startTime time.Time
 	lastScan  time.Time
 
-	lastUpdate *time.Time
-
-	settings Settings
+	settings        Settings
+	resolvers       []NameResolver
+	remoteResolvers []NameResolver // only used in the background
+	lookups         nameLookups
 
 	Games      *GameList
 	ImageCache *GameImages
+	Albums     *AlbumList
+	Overrides  *OverrideList
+	Aliases    *AliasList
+	Banners    *BannerStore
 
 	SettingsFile string
 	StaticFiles fs.FS
 
 	newImages chan NewImage
+
+	search *searchIndex
 }
 
 func NewServer(settingsFile string) (*Server, error) {
@@ -81,6 +96,7 @@ func NewServer(settingsFile string) (*Server, error) {
 		SettingsFile: settingsFile,
 		StaticFiles: &staticFiles{},
 		newImages: make(chan NewImage, 1000),
+		search: &searchIndex{},
 	}
 
 	if err := s.loadSettings(settingsFile); err != nil {
@@ -110,19 +126,68 @@ func NewServer(settingsFile string) (*Server, error) {
 	return s, nil
 }
 
+type route struct {
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// routes lists every endpoint the server handles.  Each of these needs an
+// entry in openapi.json; openapi_test.go checks that they match.
+func (s *Server) routes() []route {
+	return []route{
+		{"/{$}", s.handler_main},
+		{"/game/{appid}/{$}", s.handler_game},
+		{"GET /game/{appid}/download.zip", s.handler_download_game},
+		{"POST /download.zip", s.handler_download_selection},
+		{"/timeline", s.handler_timeline},
+		{"/favorites", s.handler_favorites},
+		{"/search", s.handler_search},
+		{"/albums", s.handler_albums},
+		{"/album/{id}", s.handler_album},
+		{"/admin/overrides", s.handler_admin_overrides},
+		{"/thumb/{appid}/{filename}", s.handler_thumb},
+		{"/preview/{appid}/{filename}", s.handler_preview},
+		{"/img/{appid}/{filename}", s.handler_image},
+		{"/static/{filename}", s.handler_static},
+		{"/static/{subdir}/{filename}", s.handler_static},
+		{"/debug/", s.handler_debug},
+		{"GET /api/openapi.json", s.handler_api_openapi},
+		{"/api/get-cache", s.handler_api_cache},
+		{"GET /api/timeline", s.handler_api_timeline},
+		{"GET /api/game/{appid}/images", s.handler_api_game_images},
+		{"GET /api/favorites", s.handler_api_favorites},
+		{"GET /api/v1/games", s.handler_api_v1_games},
+		{"PUT /api/v1/games/names", s.handler_api_v1_game_names},
+		{"PUT /api/v1/games/info", s.handler_api_v1_game_info},
+		{"PUT /api/v1/games/{appid}/banner", s.handler_api_v1_set_banner},
+		{"DELETE /api/v1/games/{appid}/banner", s.handler_api_v1_delete_banner},
+		{"GET /api/v1/games/{appid}/images", s.handler_api_v1_game_images},
+		{"GET /api/v1/images/{appid}/{filename}", s.handler_api_v1_image},
+		{"PATCH /api/v1/images/{appid}/{filename}", s.handler_api_v1_edit_image},
+		{"GET /api/v1/search", s.handler_api_v1_search},
+		{"GET /api/v1/overrides", s.handler_api_v1_overrides},
+		{"PUT /api/v1/overrides/{appid}", s.handler_api_v1_set_override},
+		{"DELETE /api/v1/overrides/{appid}", s.handler_api_v1_delete_override},
+		{"GET /api/v1/aliases", s.handler_api_v1_aliases},
+		{"PUT /api/v1/aliases/{appid}", s.handler_api_v1_set_alias},
+		{"DELETE /api/v1/aliases/{appid}", s.handler_api_v1_delete_alias},
+		{"GET /api/v1/albums", s.handler_api_v1_albums},
+		{"POST /api/v1/albums", s.handler_api_v1_create_album},
+		{"GET /api/v1/albums/{id}", s.handler_api_v1_album},
+		{"PATCH /api/v1/albums/{id}", s.handler_api_v1_edit_album},
+		{"DELETE /api/v1/albums/{id}", s.handler_api_v1_delete_album},
+		{"POST /api/v1/albums/{id}/images", s.handler_api_v1_add_album_images},
+		{"PUT /api/upload/{appid}/{filename}", s.handler_api_upload},
+	}
+}
+
 func (s *Server) Run() error {
 	fmt.Println("Starting server")
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/{$}", s.handler_main)
-	mux.HandleFunc("/game/{appid}/{$}", s.handler_game)
-	mux.HandleFunc("/thumb/{appid}/{filename}", s.handler_thumb)
-	mux.HandleFunc("/img/{appid}/{filename}", s.handler_image)
-	mux.HandleFunc("/static/{filename}", s.handler_static)
-	mux.HandleFunc("/static/{subdir}/{filename}", s.handler_static)
-	mux.HandleFunc("/debug/", s.handler_debug)
-	mux.HandleFunc("/api/get-cache", s.handler_api_cache)
-	mux.HandleFunc("PUT /api/upload/{appid}/{filename}", s.handler_api_upload)
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.Pattern, rt.Handler)
+	}
 
 	server := &http.Server{
 		Addr:           s.settings.Address,
@@ -133,7 +198,7 @@ func (s *Server) Run() error {
 	}
 
 	var err error
-	s.ImageCache, err = LoadImageCache("image.cache", s.settings.ImageDirectory)
+	s.ImageCache, err = LoadImageCache(ImageCacheFile, s.settings.ImageDirectory)
 	if err != nil {
 		return fmt.Errorf("error loading image cache: %w", err)
 	}
@@ -144,6 +209,8 @@ func (s *Server) Run() error {
 	}
 
 	go s.imageAdder()
+	go s.refreshGameList()
+	go s.refreshGameDetails()
 
 	// Generate a new API key if it's empty
 	if s.settings.ApiKey == "" {
@@ -217,7 +284,8 @@ func (s *Server) saveSettings(filename string) error {
 	return os.Chmod(filename, 0600)
 }
 
-func (s *Server) loadSettings(filename string) error {
+// readSettings only reads the settings file.  See loadSettings.
+func (s *Server) readSettings(filename string) error {
 	settingsFile, err := os.ReadFile(filename)
 	if err != nil {
 		return fmt.Errorf("Error reading settings file: %s", err)
@@ -229,130 +297,97 @@ func (s *Server) loadSettings(filename string) error {
 	}
 
 	fmt.Println("Settings loaded")
-
-	// TODO: make this filename configurable
-	s.Games, err = LoadGameList("games.cache")
-	return err
+	return nil
 }
 
-func (s *Server) getGameName(appid string) (string, error) {
-	if appid == ".stfolder" {
-		return appid, nil
-	}
-
-	//fmt.Printf("Getting name for appid %q\n", appid)
-	if name := s.Games.Get(appid); name != appid {
-		return name, nil
-	}
-
-	// Large appid, must be a non-steam game.  This could have some edge cases
-	// as non-steam games' appids are CRCs.
-	if len(appid) > 18 {
-		return s.Games.Set(appid, fmt.Sprintf("Non-Steam game (%s)", appid)), nil
-	}
-
-	if err := s.updateGamesJson(); err == nil {
-		if name := s.Games.Get(appid); name != appid {
-			return name, nil
-		}
+func (s *Server) loadSettings(filename string) error {
+	err := s.readSettings(filename)
+	if err != nil {
+		return err
 	}
-	return appid, nil
-}
 
-// Update the local cache of appids from steam's servers.
-func (s *Server) updateGamesJson() error {
-	if s.lastUpdate != nil && time.Since(*s.lastUpdate).Minutes() < 30 {
-		//return fmt.Errorf("Cache still good.")
-		fmt.Println("Not updating games list; cache still good.")
-		return nil
+	// TODO: make this filename configurable
+	s.Games, err = LoadGameList(GameCacheFile)
+	if err != nil {
+		return err
 	}
 
-	now := time.Now()
-	//fmt.Printf("time.Now(): {}\n", now)
-	s.lastUpdate = &now
-
-	fmt.Println("Updating games list")
-	resp, err := http.Get("http://api.steampowered.com/ISteamApps/GetAppList/v2")
+	s.Albums, err = LoadAlbums(AlbumsFile)
 	if err != nil {
-		return fmt.Errorf("Unable to get appid list from steam: %s", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	js, err := io.ReadAll(resp.Body)
+	migrate := !exists(OverridesFile)
+	s.Overrides, err = LoadOverrides(OverridesFile, s.settings.AppidOverrides)
 	if err != nil {
-		return fmt.Errorf("Unable to read appid json: %s", err)
+		return err
 	}
 
-	alist := &steamapps{}
-	if err := json.Unmarshal(js, alist); err != nil {
-		return fmt.Errorf("Unable to unmarshal json: %s", err)
+	// Overrides only live in one place.
+	if len(s.settings.AppidOverrides) > 0 {
+		if migrate {
+			s.settings.AppidOverrides = nil
+			if err = s.saveSettings(filename); err != nil {
+				return fmt.Errorf("unable to remove AppidOverrides from %s: %w", filename, err)
+			}
+			fmt.Printf("Removed AppidOverrides from %s; they're in %s now\n", filename, OverridesFile)
+		} else {
+			fmt.Printf("AppidOverrides in %s are ignored; overrides are kept in %s\n", filename, OverridesFile)
+		}
 	}
 
-	for _, a := range alist.Applist.Apps {
-		id := fmt.Sprintf("%d", a.Appid)
-		s.Games.Set(id, a.Name)
+	s.Aliases, err = LoadAliases(AliasesFile)
+	if err != nil {
+		return err
 	}
 
-	for _, ovr := range s.settings.AppidOverrides {
-		s.Games.Set(ovr.Appid, ovr.Name)
-		fmt.Printf("Setting override for [%s]: %q\n", ovr.Appid, ovr.Name)
+	bannerUrl := s.settings.BannerUrl
+	if bannerUrl == "" {
+		bannerUrl = DefaultBannerUrl
 	}
-
-	// save games.cache
-	games := s.Games.GetMap()
-	marshaled, err := json.MarshalIndent(games, "", "\t")
-	if err != nil {
-		return fmt.Errorf("Unable to marshal game json: %s", err)
+	maxAge := s.settings.BannerMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultBannerMaxAge
 	}
-
-	err = os.WriteFile("games.cache", marshaled, 0644)
+	s.Banners, err = LoadBanners(BannerDirectory, strings.TrimSuffix(bannerUrl, "/"), time.Duration(maxAge)*24*time.Hour)
 	if err != nil {
-		return fmt.Errorf("Unable to save games.cache: %s", err)
+		return err
 	}
 
-	fmt.Printf("Finished updating games list.  Appids: %d\n", len(games))
-	return nil
+	s.resolvers, s.remoteResolvers, err = s.newResolvers()
+	return err
 }
 
-// Returns a filename
-func (s *Server) getGameBanner(appid string) (string, error) {
-	//appstr := fmt.Sprintf("%d", appid)
-	if exist := exists("banners/" + appid + ".jpg"); exist {
-		return "banners/" + appid + ".jpg", nil
-	}
-
-	resp, err := http.Get("http://cdn.akamai.steamstatic.com/steam/apps/" + appid + "/header.jpg")
-	if err != nil {
-		return "", fmt.Errorf("Unable to DL header: %s", err)
+// getGameName returns the name for appid without waiting on the network.  If
+// it isn't known yet the appid is returned and the name is looked up in the
+// background.
+func (s *Server) getGameName(appid string) (string, error) {
+	if appid == ".stfolder" {
+		return appid, nil
 	}
 
-	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-		// Game not found.  Use unknown.
-
-		raw, err := os.ReadFile("banners/unknown.jpg")
+	for _, r := range s.resolvers {
+		name, ok, err := r.ResolveName(appid)
 		if err != nil {
-			return "", fmt.Errorf("Unable to read unknown.jpg")
+			fmt.Printf("Error resolving name for %s: %s\n", appid, err)
+			continue
 		}
 
-		if err = os.WriteFile("banners/"+appid+".jpg", raw, 0777); err != nil {
-			return "", fmt.Errorf("Unable to save file: %s", err)
+		if ok {
+			// Keep the cache in sync for pages that read it directly.  The
+			// non-Steam placeholder is left out so a real name can replace it.
+			_, placeholder := r.(NonSteamResolver)
+			if cached, _ := s.Games.Lookup(appid); cached != name && !placeholder {
+				s.Games.Set(appid, name)
+			}
+			return name, nil
 		}
-
-		return "banners/" + appid + ".jpg", nil
-	}
-
-	defer resp.Body.Close()
-
-	file, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("Unable to read file: %s", err)
 	}
 
-	if err = os.WriteFile("banners/"+appid+".jpg", file, 0777); err != nil {
-		return "", fmt.Errorf("Unable to save file: %s", err)
+	if len(s.remoteResolvers) > 0 {
+		s.lookups.Go(appid, func() { s.lookupName(appid) })
 	}
-
-	return "banners/" + appid + ".jpg", nil
+	return appid, nil
 }
 
 // exists returns whether the given file or directory exists or not.
@@ -10,7 +10,7 @@ import (
 	"time"
 )
 
-//go:embed static templates banners/unknown.jpg
+//go:embed static templates banners/unknown.jpg openapi.json
 var embeddedContent embed.FS
 
 type staticFiles struct {
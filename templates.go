@@ -13,6 +13,7 @@ type TemplateData struct {
 	Header        map[string]string
 	Body          []map[string]template.JS
 	ImageMetadata []Metadata
+	Highlights    []Metadata // The "best of" strip above a gallery
 }
 
 func init_templates() error {
@@ -20,12 +21,15 @@ func init_templates() error {
 		"main",
 		"list",
 		"debug",
-		//"edit",
+		"timeline",
+		"search",
+		"albums",
+		"overrides",
 	}
 
 	templates = make(map[string]*template.Template)
 	for _, t := range template_list {
-		if temp, err := template.New(t).ParseFS(embeddedContent, "templates/base.html", "templates/"+t+".html"); err != nil {
+		if temp, err := template.New(t).ParseFS(embeddedContent, "templates/base.html", "templates/photoswipe.html", "templates/edit.html", "templates/"+t+".html"); err != nil {
 			return fmt.Errorf("Unable to load %q template: %s", t, err)
 		} else {
 			templates[t] = temp
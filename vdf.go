@@ -0,0 +1,394 @@
+package steamscreenshots
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VDFMap is a decoded KeyValues object from one of Steam's VDF files.  Values
+// are either a nested VDFMap, a string, a uint32, a uint64 or a float32.
+type VDFMap map[string]any
+
+// Get looks up a key ignoring case.  Steam isn't consistent about the case of
+// keys between versions.
+func (m VDFMap) Get(key string) (any, bool) {
+	if val, ok := m[key]; ok {
+		return val, true
+	}
+	for k, val := range m {
+		if strings.EqualFold(k, key) {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+func (m VDFMap) GetString(key string) string {
+	val, _ := m.Get(key)
+	str, _ := val.(string)
+	return str
+}
+
+func (m VDFMap) GetMap(key string) VDFMap {
+	val, _ := m.Get(key)
+	sub, _ := val.(VDFMap)
+	return sub
+}
+
+// Value types in binary VDF files.
+const (
+	vdfMap     byte = 0x00
+	vdfString  byte = 0x01
+	vdfInt32   byte = 0x02
+	vdfFloat32 byte = 0x03
+	vdfUint64  byte = 0x07
+	vdfEnd     byte = 0x08
+	vdfInt64   byte = 0x0A
+)
+
+// ParseBinaryVDF reads a binary VDF file, like shortcuts.vdf.
+func ParseBinaryVDF(r io.Reader) (VDFMap, error) {
+	br := bufio.NewReader(r)
+	m, err := parseBinaryVDFMap(br, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary VDF: %w", err)
+	}
+	return m, nil
+}
+
+func parseBinaryVDFMap(r *bufio.Reader, depth int) (VDFMap, error) {
+	if depth > 32 {
+		return nil, errors.New("nested too deeply")
+	}
+
+	m := VDFMap{}
+	for {
+		typ, err := r.ReadByte()
+		if err == io.EOF && depth == 0 {
+			// Some files are missing the final end marker.
+			return m, nil
+		} else if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if typ == vdfEnd {
+			return m, nil
+		}
+
+		key, err := readCString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case vdfMap:
+			m[key], err = parseBinaryVDFMap(r, depth+1)
+		case vdfString:
+			m[key], err = readCString(r)
+		case vdfInt32:
+			var val uint32
+			err = binary.Read(r, binary.LittleEndian, &val)
+			m[key] = val
+		case vdfFloat32:
+			var val uint32
+			err = binary.Read(r, binary.LittleEndian, &val)
+			m[key] = math.Float32frombits(val)
+		case vdfUint64, vdfInt64:
+			var val uint64
+			err = binary.Read(r, binary.LittleEndian, &val)
+			m[key] = val
+		default:
+			return nil, fmt.Errorf("unknown type 0x%02X for key %q", typ, key)
+		}
+
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+}
+
+func readCString(r *bufio.Reader) (string, error) {
+	str, err := r.ReadString(0)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return str[:len(str)-1], nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ParseTextVDF reads a text VDF file, like screenshots.vdf.  Every value is
+// either a string or a nested VDFMap.  Conditionals like [$WIN32] are
+// ignored.
+func ParseTextVDF(r io.Reader) (VDFMap, error) {
+	t := &vdfTokenizer{r: bufio.NewReader(r), line: 1}
+	m, err := t.parseMap(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VDF on line %d: %w", t.line, err)
+	}
+	return m, nil
+}
+
+type vdfTokenizer struct {
+	r    *bufio.Reader
+	line int
+}
+
+// next returns the next token.  isString is false for braces so a quoted "{"
+// is still a string.
+func (t *vdfTokenizer) next() (tok string, isString bool, err error) {
+	for {
+		c, err := t.r.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+
+		switch {
+		case c == '\n':
+			t.line++
+		case c == ' ' || c == '\t' || c == '\r':
+		case c == '{' || c == '}':
+			return string(c), false, nil
+		case c == '/':
+			if next, _ := t.r.Peek(1); len(next) == 1 && next[0] == '/' {
+				if _, err = t.r.ReadString('\n'); err != nil {
+					return "", false, err
+				}
+				t.line++
+				continue
+			}
+			return t.unquoted(c)
+		case c == '"':
+			return t.quoted()
+		default:
+			return t.unquoted(c)
+		}
+	}
+}
+
+func (t *vdfTokenizer) quoted() (string, bool, error) {
+	sb := &strings.Builder{}
+	for {
+		c, err := t.r.ReadByte()
+		if err != nil {
+			return "", false, unexpectedEOF(err)
+		}
+
+		switch c {
+		case '"':
+			return sb.String(), true, nil
+		case '\n':
+			t.line++
+		case '\\':
+			c, err = t.r.ReadByte()
+			if err != nil {
+				return "", false, unexpectedEOF(err)
+			}
+			switch c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+		}
+		sb.WriteByte(c)
+	}
+}
+
+func (t *vdfTokenizer) unquoted(first byte) (string, bool, error) {
+	sb := &strings.Builder{}
+	sb.WriteByte(first)
+	for {
+		next, err := t.r.Peek(1)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", false, err
+		}
+
+		c := next[0]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '"' || c == '{' || c == '}' {
+			break
+		}
+		t.r.ReadByte()
+		sb.WriteByte(c)
+	}
+	return sb.String(), true, nil
+}
+
+// skipConditional skips a conditional like [$WIN32] after a value.
+func (t *vdfTokenizer) skipConditional() error {
+	for {
+		next, err := t.r.Peek(1)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch next[0] {
+		case ' ', '\t':
+			t.r.ReadByte()
+		case '[':
+			_, err = t.r.ReadString(']')
+			return unexpectedEOF(err)
+		default:
+			return nil
+		}
+	}
+}
+
+func (t *vdfTokenizer) parseMap(depth int) (VDFMap, error) {
+	if depth > 32 {
+		return nil, errors.New("nested too deeply")
+	}
+
+	m := VDFMap{}
+	for {
+		key, isString, err := t.next()
+		if err == io.EOF && depth == 0 {
+			return m, nil
+		} else if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if !isString {
+			if key == "}" && depth > 0 {
+				return m, nil
+			}
+			return nil, fmt.Errorf("unexpected %q", key)
+		}
+
+		val, isString, err := t.next()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !isString {
+			if val != "{" {
+				return nil, fmt.Errorf("unexpected %q", val)
+			}
+			m[key], err = t.parseMap(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		m[key] = val
+
+		if err = t.skipConditional(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ParseScreenshots reads Steam's screenshots.vdf.  The result is keyed by
+// "<appid>/<filename>".
+func ParseScreenshots(r io.Reader) (map[string]SteamScreenshot, error) {
+	vdf, err := ParseTextVDF(r)
+	if err != nil {
+		return nil, err
+	}
+
+	screenshots := map[string]SteamScreenshot{}
+	for appid, val := range vdf.GetMap("screenshots") {
+		game, ok := val.(VDFMap)
+		if !ok {
+			// eg, shortcutnames
+			continue
+		}
+
+		for _, val := range game {
+			entry, ok := val.(VDFMap)
+			if !ok {
+				continue
+			}
+
+			filename := path.Base(strings.ReplaceAll(entry.GetString("filename"), "\\", "/"))
+			if filename == "." || filename == "/" {
+				continue
+			}
+
+			sc := SteamScreenshot{
+				Caption:  entry.GetString("caption"),
+				Location: entry.GetString("location"),
+			}
+
+			if id := entry.GetString("publishedfileid"); id != "0" {
+				sc.PublishedFileId = id
+			}
+
+			if created, err := strconv.ParseInt(entry.GetString("creation"), 10, 64); err == nil && created > 0 {
+				sc.Created = time.Unix(created, 0)
+			}
+
+			screenshots[appid+"/"+filename] = sc
+		}
+	}
+	return screenshots, nil
+}
+
+// Shortcut is a non-Steam game added to a Steam library.
+type Shortcut struct {
+	AppId uint32
+	Name  string
+	Exe   string
+}
+
+// GameId is the id of the shortcut's screenshot folder.
+func (s Shortcut) GameId() string {
+	return strconv.FormatUint(uint64(s.AppId)<<32|0x02000000, 10)
+}
+
+// ShortcutAppId computes the id Steam gives a shortcut.  Newer versions of
+// Steam store this in shortcuts.vdf, but older files don't have it.
+func ShortcutAppId(exe, name string) uint32 {
+	return crc32.ChecksumIEEE([]byte(exe+name)) | 0x80000000
+}
+
+// ParseShortcuts reads the shortcuts from a shortcuts.vdf file.
+func ParseShortcuts(r io.Reader) ([]Shortcut, error) {
+	vdf, err := ParseBinaryVDF(r)
+	if err != nil {
+		return nil, err
+	}
+
+	shortcuts := []Shortcut{}
+	for _, val := range vdf.GetMap("shortcuts") {
+		entry, ok := val.(VDFMap)
+		if !ok {
+			continue
+		}
+
+		sc := Shortcut{
+			Name: entry.GetString("AppName"),
+			Exe:  entry.GetString("Exe"),
+		}
+		if sc.Name == "" {
+			continue
+		}
+
+		if id, ok := entry.Get("appid"); ok {
+			sc.AppId, _ = id.(uint32)
+		}
+		if sc.AppId == 0 {
+			sc.AppId = ShortcutAppId(sc.Exe, sc.Name)
+		}
+
+		shortcuts = append(shortcuts, sc)
+	}
+	return shortcuts, nil
+}
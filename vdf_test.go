@@ -0,0 +1,172 @@
+package steamscreenshots
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vdfWriter builds binary VDF files for tests.
+type vdfWriter struct {
+	bytes.Buffer
+}
+
+func (w *vdfWriter) key(typ byte, key string) {
+	w.WriteByte(typ)
+	w.WriteString(key)
+	w.WriteByte(0)
+}
+
+func (w *vdfWriter) str(key, val string) {
+	w.key(vdfString, key)
+	w.WriteString(val)
+	w.WriteByte(0)
+}
+
+func (w *vdfWriter) int32(key string, val uint32) {
+	w.key(vdfInt32, key)
+	binary.Write(w, binary.LittleEndian, val)
+}
+
+func (w *vdfWriter) begin(key string) { w.key(vdfMap, key) }
+func (w *vdfWriter) end()             { w.WriteByte(vdfEnd) }
+
+func TestParseShortcuts(t *testing.T) {
+	w := &vdfWriter{}
+	w.begin("shortcuts")
+
+	// Newer versions of Steam store the appid.
+	w.begin("0")
+	w.int32("appid", 0x9ABCDEF0)
+	w.str("AppName", "Some Emulator")
+	w.str("Exe", `"C:\emu\emu.exe"`)
+	w.int32("IsHidden", 0)
+	w.begin("tags")
+	w.str("0", "favorite")
+	w.end()
+	w.end()
+
+	// Older ones don't, and use lowercase keys.
+	w.begin("1")
+	w.str("appname", "Old Game")
+	w.str("exe", `"C:\old\game.exe"`)
+	w.end()
+
+	w.end()
+	w.end()
+
+	shortcuts, err := ParseShortcuts(bytes.NewReader(w.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shortcuts) != 2 {
+		t.Fatalf("expected 2 shortcuts, got %d", len(shortcuts))
+	}
+
+	found := map[string]Shortcut{}
+	for _, sc := range shortcuts {
+		found[sc.Name] = sc
+	}
+
+	if sc := found["Some Emulator"]; sc.AppId != 0x9ABCDEF0 || sc.GameId() != "11150031899869577216" {
+		t.Errorf("unexpected ids for stored appid: %d, %s", sc.AppId, sc.GameId())
+	}
+
+	if sc := found["Old Game"]; sc.AppId != ShortcutAppId(`"C:\old\game.exe"`, "Old Game") {
+		t.Errorf("unexpected computed appid: %d", sc.AppId)
+	}
+	if id := ShortcutAppId(`"C:\old\game.exe"`, "Old Game"); id&0x80000000 == 0 {
+		t.Errorf("computed appid %d is missing the high bit", id)
+	}
+}
+
+func TestParseBinaryVDFErrors(t *testing.T) {
+	w := &vdfWriter{}
+	w.begin("shortcuts")
+	w.str("name", "unterminated")
+
+	_, err := ParseBinaryVDF(bytes.NewReader(w.Bytes()[:w.Len()-3]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+
+	_, err = ParseBinaryVDF(bytes.NewReader([]byte{0x42, 'k', 0}))
+	if err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+const testScreenshotsVdf = `"Screenshots"
+{
+	// comments are allowed
+	"440"
+	{
+		"0"
+		{
+			"type"		"1"
+			"filename"		"440/screenshots/20240101123456_1.jpg"
+			"width"		"1920"
+			"height"		"1080"
+			"creation"		"1704112496"
+			"location"		"ctf_2fort"
+			"caption"		"a \"quoted\" caption\\with a backslash"
+			"publishedfileid"		"0"
+		}
+		"1"
+		{
+			"filename"		"440\\screenshots\\20240101123500_1.jpg"
+			"creation"		"1704112500"	[$WIN32]
+			"caption"		""
+			"publishedfileid"		"3141592653"
+		}
+	}
+	"shortcutnames"
+	{
+		"11150031899869577216"		"Some Emulator"
+	}
+}
+`
+
+func TestParseScreenshots(t *testing.T) {
+	screenshots, err := ParseScreenshots(strings.NewReader(testScreenshotsVdf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(screenshots) != 2 {
+		t.Fatalf("expected 2 screenshots, got %d: %v", len(screenshots), screenshots)
+	}
+
+	first := screenshots["440/20240101123456_1.jpg"]
+	if first.Caption != `a "quoted" caption\with a backslash` {
+		t.Errorf("unexpected caption %q", first.Caption)
+	}
+	if first.Location != "ctf_2fort" || first.PublishedFileId != "" {
+		t.Errorf("unexpected details: %+v", first)
+	}
+	if !first.Created.Equal(time.Unix(1704112496, 0)) {
+		t.Errorf("unexpected creation time %s", first.Created)
+	}
+
+	second := screenshots["440/20240101123500_1.jpg"]
+	if second.PublishedFileId != "3141592653" || !second.Created.Equal(time.Unix(1704112500, 0)) {
+		t.Errorf("unexpected details: %+v", second)
+	}
+}
+
+func TestParseTextVDFErrors(t *testing.T) {
+	for _, input := range []string{
+		`"a" { "b" "c"`,
+		`"a" "unterminated`,
+		`}`,
+		`"a" }`,
+	} {
+		if _, err := ParseTextVDF(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package steamscreenshots
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"time"
+)
+
+// Video clips are only probed for their dimensions and duration.  Decoding
+// a frame would need a codec, so the thumbnail is a generated poster.
+
+type videoInfo struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+}
+
+// mp4Info reads the movie header for the duration and the first track
+// header with non-zero dimensions for the size.
+func mp4Info(r io.Reader) (videoInfo, error) {
+	boxes, err := readBmffBoxes(r, "moov")
+	if err != nil {
+		return videoInfo{}, err
+	}
+
+	info := videoInfo{}
+	mvhd := bmffFind(boxes, "moov", "mvhd")
+	if len(mvhd) == 0 {
+		return info, fmt.Errorf("missing movie header")
+	}
+
+	data := mvhd[0].Data
+	var timescale, duration uint64
+	switch {
+	case len(data) >= 32 && data[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	case len(data) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+
+	if timescale > 0 {
+		info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+
+	for _, tkhd := range bmffFind(boxes, "moov", "trak", "tkhd") {
+		// Width and height are 16.16 fixed point at the end of the box.
+		offset := 76
+		if len(tkhd.Data) > 0 && tkhd.Data[0] == 1 {
+			offset = 88
+		}
+
+		if len(tkhd.Data) < offset+8 {
+			continue
+		}
+
+		w := int(binary.BigEndian.Uint32(tkhd.Data[offset:]) >> 16)
+		h := int(binary.BigEndian.Uint32(tkhd.Data[offset+4:]) >> 16)
+		if w > 0 && h > 0 {
+			info.Width, info.Height = w, h
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// EBML element IDs used by webmInfo.
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlCluster       = 0x1F43B675
+
+	// Info and Tracks are a few kilobytes, more with codec private data.
+	maxEbmlElement = 1 << 20
+)
+
+// webmInfo reads the segment info and track list of a WebM file.  Both come
+// before the first cluster, so reading stops there.
+func webmInfo(r io.Reader) (videoInfo, error) {
+	info := videoInfo{}
+	timecodeScale := uint64(1000000)
+	var duration float64
+
+	for {
+		id, size, err := readEbmlHeader(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return info, err
+		}
+
+		switch id {
+		case ebmlSegment:
+			// descend into the segment
+			continue
+
+		case ebmlInfo, ebmlTracks:
+			if size < 0 {
+				return info, fmt.Errorf("unknown size for element %X", id)
+			}
+			if size > maxEbmlElement {
+				return info, fmt.Errorf("element %X is too large: %d bytes", id, size)
+			}
+
+			data := make([]byte, size)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return info, err
+			}
+
+			if id == ebmlInfo {
+				for _, el := range ebmlChildren(data) {
+					switch el.ID {
+					case ebmlTimecodeScale:
+						timecodeScale = ebmlUint(el.Data)
+					case ebmlDuration:
+						duration = ebmlFloat(el.Data)
+					}
+				}
+			} else {
+				webmTrackSize(data, &info)
+			}
+
+		case ebmlCluster:
+			// media data from here on
+			info.Duration = time.Duration(duration * float64(timecodeScale))
+			return info, nil
+
+		default:
+			if size < 0 {
+				return info, fmt.Errorf("unknown size for element %X", id)
+			}
+			if err = skipBytes(r, size); err != nil {
+				return info, err
+			}
+		}
+	}
+
+	info.Duration = time.Duration(duration * float64(timecodeScale))
+	return info, nil
+}
+
+func webmTrackSize(tracks []byte, info *videoInfo) {
+	for _, entry := range ebmlChildren(tracks) {
+		if entry.ID != ebmlTrackEntry {
+			continue
+		}
+
+		for _, el := range ebmlChildren(entry.Data) {
+			if el.ID != ebmlVideo {
+				continue
+			}
+
+			for _, v := range ebmlChildren(el.Data) {
+				switch v.ID {
+				case ebmlPixelWidth:
+					info.Width = int(ebmlUint(v.Data))
+				case ebmlPixelHeight:
+					info.Height = int(ebmlUint(v.Data))
+				}
+			}
+
+			if info.Width > 0 && info.Height > 0 {
+				return
+			}
+		}
+	}
+}
+
+type ebmlElement struct {
+	ID   uint64
+	Data []byte
+}
+
+// readEbmlHeader reads an element ID and data size.  A size of -1 means the
+// size is unknown.
+func readEbmlHeader(r io.Reader) (uint64, int64, error) {
+	id, _, err := readVint(r, true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, length, err := readVint(r, false)
+	if err == io.EOF {
+		return 0, 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	// all ones means unknown
+	if size == (1<<(7*uint(length)))-1 {
+		return id, -1, nil
+	}
+	return id, int64(size), nil
+}
+
+// readVint reads an EBML variable length integer.  IDs keep their length
+// marker bit, sizes don't.
+func readVint(r io.Reader, keepMarker bool) (uint64, int, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, 0, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && b[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("invalid EBML variable length integer")
+	}
+
+	val := uint64(b[0])
+	if !keepMarker {
+		val &= uint64(0xFF >> length)
+	}
+
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, 0, err
+	}
+
+	for _, c := range rest {
+		val = val<<8 | uint64(c)
+	}
+	return val, length, nil
+}
+
+func ebmlChildren(data []byte) []ebmlElement {
+	elements := []ebmlElement{}
+	r := &byteReader{data: data}
+
+	for {
+		id, size, err := readEbmlHeader(r)
+		if err != nil || size < 0 || size > int64(len(r.data)-r.pos) {
+			return elements
+		}
+
+		elements = append(elements, ebmlElement{
+			ID:   id,
+			Data: r.data[r.pos : r.pos+int(size)],
+		})
+		r.pos += int(size)
+	}
+}
+
+func ebmlUint(data []byte) uint64 {
+	val := uint64(0)
+	for _, b := range data {
+		val = val<<8 | uint64(b)
+	}
+	return val
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+type byteReader struct {
+	data []byte
+	pos  int
+}
+
+func (br *byteReader) Read(p []byte) (int, error) {
+	if br.pos >= len(br.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, br.data[br.pos:])
+	br.pos += n
+	return n, nil
+}
+
+// videoPoster draws a placeholder thumbnail with a play symbol and the
+// clip's duration.
+func videoPoster(info videoInfo, width int) image.Image {
+	img := placeholderThumbnail(image.Config{Width: info.Width, Height: info.Height}, width, "")
+
+	// play triangle in the middle
+	size := img.Bounds().Dy() / 3
+	cx, cy := width/2, img.Bounds().Dy()/2
+	for y := -size / 2; y <= size/2; y++ {
+		span := size/2 - int(math.Abs(float64(y)))
+		for x := 0; x <= span; x++ {
+			img.Set(cx-size/4+x, cy+y, color.White)
+		}
+	}
+
+	drawLabel(img, formatDuration(info.Duration), img.Bounds().Dy()-6)
+	return img
+}
+
+// formatDuration returns m:ss or h:mm:ss.
+func formatDuration(d time.Duration) string {
+	secs := int(d.Round(time.Second).Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}